@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const progressBarWidth = 10
+
+// Achievement is a one-time goal. Progress returns how far u is and the
+// target; the achievement unlocks once the first reaches the second.
+type Achievement struct {
+	ID          string
+	Name        string
+	Description string
+	Decimals    int
+	Progress    func(u *User) (current, goal float64)
+	Reward      BonusReward
+}
+
+var achievementCatalog = []Achievement{
+	{
+		ID: "first_gpu", Name: "Первая видеокарта", Description: "Купите любую видеокарту",
+		Progress: func(u *User) (float64, float64) { return float64(len(u.Inventory)), 1 },
+		Reward:   BonusReward{Kind: rewardUSD, Amount: 100},
+	},
+	{
+		ID: "gpu_10", Name: "Десяток", Description: "Соберите 10 видеокарт",
+		Progress: func(u *User) (float64, float64) { return float64(len(u.Inventory)), 10 },
+		Reward:   BonusReward{Kind: rewardBTC, Amount: 0.005},
+	},
+	{
+		ID: "full_farm", Name: "Полная ферма", Description: "Заполните все места на ферме",
+		Progress: func(u *User) (float64, float64) { return float64(len(u.Inventory)), float64(u.FarmCapacity) },
+		Reward:   BonusReward{Kind: rewardGPU, GPUID: 38},
+	},
+	{
+		ID: "first_business", Name: "Предприниматель", Description: "Купите первый бизнес",
+		Progress: func(u *User) (float64, float64) { return float64(len(u.Businesses)), 1 },
+		Reward:   BonusReward{Kind: rewardUSD, Amount: 500},
+	},
+	{
+		ID: "businesses_5", Name: "Магнат", Description: "Владейте пятью бизнесами",
+		Progress: func(u *User) (float64, float64) { return float64(len(u.Businesses)), 5 },
+		Reward:   BonusReward{Kind: rewardUSD, Amount: 25000},
+	},
+	{
+		ID: "mined_1btc", Name: "Первый биткоин", Description: "Добудьте 1 BTC на ферме",
+		Decimals: 3,
+		Progress: func(u *User) (float64, float64) { return u.MinedBTC, 1 },
+		Reward:   BonusReward{Kind: rewardBoost, Amount: 2, Duration: 2 * time.Hour},
+	},
+	{
+		ID: "streak_7", Name: "Неделя подряд", Description: "Забирайте ежедневный бонус 7 дней подряд",
+		Progress: func(u *User) (float64, float64) { return float64(u.BonusStreak), 7 },
+		Reward:   BonusReward{Kind: rewardBTC, Amount: 0.01},
+	},
+	{
+		ID: "streak_30", Name: "Месяц подряд", Description: "Забирайте ежедневный бонус 30 дней подряд",
+		Progress: func(u *User) (float64, float64) { return float64(u.BonusStreak), 30 },
+		Reward:   BonusReward{Kind: rewardGPU, GPUID: 30},
+	},
+	{
+		ID: "millionaire", Name: "Миллионер", Description: "Накопите 1 000 000 $",
+		Progress: func(u *User) (float64, float64) { return u.BalanceUSD, 1e6 },
+		Reward:   BonusReward{Kind: rewardBoost, Amount: 2, Duration: 6 * time.Hour},
+	},
+	{
+		ID: "clan", Name: "Командный игрок", Description: "Вступите в клан или создайте свой",
+		Progress: func(u *User) (float64, float64) {
+			if u.ClanID != 0 {
+				return 1, 1
+			}
+			return 0, 1
+		},
+		Reward: BonusReward{Kind: rewardUSD, Amount: 1000},
+	},
+}
+
+func validAchievement(id string) bool {
+	for _, a := range achievementCatalog {
+		if a.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func achievementUnlocked(u *User, id string) bool {
+	_, ok := u.Achievements[id]
+	return ok
+}
+
+// checkAchievements unlocks every achievement u has reached, pays its reward
+// and notifies the player. It is called after each mutation that can move
+// achievement progress.
+func checkAchievements(u *User) {
+	now := time.Now()
+	for _, a := range achievementCatalog {
+		if achievementUnlocked(u, a.ID) {
+			continue
+		}
+		if current, goal := a.Progress(u); goal <= 0 || current < goal {
+			continue
+		}
+		if u.Achievements == nil {
+			u.Achievements = map[string]time.Time{}
+		}
+		u.Achievements[a.ID] = now
+		paid := grantBonusReward(u, a.Reward, now)
+		markDirty(u)
+		log.Printf("User %d unlocked achievement %s", u.ID, a.ID)
+		queueNotice(u.ID, fmt.Sprintf("🏅 *Достижение получено: %s*\n\n%s\nНаграда: %s\n\n%s", a.Name, a.Description, paid, now.Format("15:04")))
+	}
+}
+
+func progressBar(fraction float64) string {
+	filled := int(min(1, max(0, fraction)) * progressBarWidth)
+	return strings.Repeat("▰", filled) + strings.Repeat("▱", progressBarWidth-filled)
+}
+
+func sendAchievements(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	text := fmt.Sprintf("🏅 *Достижения* (%d/%d)\n\n", len(u.Achievements), len(achievementCatalog))
+	for _, a := range achievementCatalog {
+		if at, ok := u.Achievements[a.ID]; ok {
+			text += fmt.Sprintf("✅ *%s* — %s\nПолучено %s\n\n", a.Name, a.Description, at.Format("02.01.2006"))
+			continue
+		}
+		current, goal := a.Progress(u)
+		fraction := 0.0
+		if goal > 0 {
+			fraction = current / goal
+		}
+		text += fmt.Sprintf("▫️ *%s* — %s\n%s %.*f/%.*f\nНаграда: %s\n\n",
+			a.Name, a.Description, progressBar(fraction), a.Decimals, min(current, goal), a.Decimals, goal, a.Reward)
+	}
+	text += currentTime
+
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+		),
+	)
+	sendMessageWithKeyboard(chatID, text, kb)
+}
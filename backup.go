@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupDir     = "data/backups"
+	backupPrefix  = "users-"
+	backupExt     = ".json.gz"
+	backupTimeFmt = "20060102T150405Z"
+)
+
+type backupInfo struct {
+	Name      string
+	CreatedAt time.Time
+	Size      int64
+}
+
+func runBackups(stop <-chan struct{}) {
+	ticker := time.NewTicker(cfg.BackupInterval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			storeMu.RLock()
+			data, err := json.MarshalIndent(store, "", "  ")
+			storeMu.RUnlock()
+			if err != nil {
+				log.Printf("Error encoding store for backup: %v", err)
+				continue
+			}
+			name, err := createBackup(data, time.Now())
+			if err != nil {
+				log.Printf("Error creating backup: %v", err)
+				continue
+			}
+			log.Printf("Created backup %s", name)
+			if err := pruneBackups(); err != nil {
+				log.Printf("Error pruning backups: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// createBackup writes a gzip-compressed snapshot together with a .sha256
+// sidecar holding the checksum of the uncompressed JSON.
+func createBackup(data []byte, now time.Time) (string, error) {
+	if err := os.MkdirAll(backupDir, 0o755); err != nil {
+		return "", err
+	}
+	name := backupPrefix + now.UTC().Format(backupTimeFmt) + backupExt
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(backupDir, name)
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	line := hex.EncodeToString(sum[:]) + "  " + name + "\n"
+	if err := writeFileAtomic(path+".sha256", []byte(line)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func listBackups() ([]backupInfo, error) {
+	entries, err := os.ReadDir(backupDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []backupInfo
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupExt) {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupExt)
+		createdAt, err := time.Parse(backupTimeFmt, ts)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backupInfo{Name: name, CreatedAt: createdAt, Size: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// backupsToKeep applies the hourly/daily/weekly retention policy: the newest
+// snapshot of each of the most recent configured number of hours, days and
+// ISO weeks survives. backups must be sorted newest first.
+func backupsToKeep(backups []backupInfo) map[string]bool {
+	keep := map[string]bool{}
+	hours := map[string]bool{}
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	for _, b := range backups {
+		hour := b.CreatedAt.Format("2006010215")
+		if len(hours) < cfg.BackupKeepHourly && !hours[hour] {
+			hours[hour] = true
+			keep[b.Name] = true
+		}
+		day := b.CreatedAt.Format("20060102")
+		if len(days) < cfg.BackupKeepDaily && !days[day] {
+			days[day] = true
+			keep[b.Name] = true
+		}
+		y, w := b.CreatedAt.ISOWeek()
+		week := fmt.Sprintf("%d-%02d", y, w)
+		if len(weeks) < cfg.BackupKeepWeekly && !weeks[week] {
+			weeks[week] = true
+			keep[b.Name] = true
+		}
+	}
+	return keep
+}
+
+func pruneBackups() error {
+	backups, err := listBackups()
+	if err != nil {
+		return err
+	}
+	keep := backupsToKeep(backups)
+	for _, b := range backups {
+		if keep[b.Name] {
+			continue
+		}
+		path := filepath.Join(backupDir, b.Name)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := os.Remove(path + ".sha256"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBackup decompresses a snapshot and verifies it against its checksum.
+func readBackup(name string) ([]byte, error) {
+	if name != filepath.Base(name) {
+		return nil, fmt.Errorf("invalid backup name %q", name)
+	}
+	path := filepath.Join(backupDir, name)
+
+	sumLine, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		return nil, fmt.Errorf("read checksum: %w", err)
+	}
+	fields := strings.Fields(string(sumLine))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("checksum file for %s is empty", name)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s: %w", name, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s: %w", name, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != fields[0] {
+		return nil, fmt.Errorf("checksum mismatch for %s: want %s, got %s", name, fields[0], got)
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%s does not contain valid JSON", name)
+	}
+	return data, nil
+}
+
+// withWorldFile returns users file data with the coins and network taken from
+// worldFile, which is newer, so that a snapshot of the files on disk is
+// complete. Without a readable world file data is returned as is.
+func withWorldFile(data []byte) []byte {
+	world, err := os.ReadFile(worldFile)
+	if err != nil {
+		return data
+	}
+	var doc, w map[string]json.RawMessage
+	if json.Unmarshal(data, &doc) != nil || json.Unmarshal(world, &w) != nil {
+		return data
+	}
+	for key, value := range w {
+		doc[key] = value
+	}
+	merged, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return data
+	}
+	return merged
+}
+
+// restoreBackup replaces the users file with a verified snapshot and removes
+// the world file, so that the snapshot's coins and network are loaded rather
+// than the newer ones. The current state is snapshotted first so a restore
+// can itself be undone.
+func restoreBackup(name string) (string, error) {
+	data, err := readBackup(name)
+	if err != nil {
+		return "", err
+	}
+	var current string
+	if cur, err := os.ReadFile(usersFile); err == nil && len(bytes.TrimSpace(cur)) > 0 {
+		current, err = createBackup(withWorldFile(cur), time.Now())
+		if err != nil {
+			return "", fmt.Errorf("back up current store: %w", err)
+		}
+	}
+	if err := writeFileAtomic(usersFile, data); err != nil {
+		return "", err
+	}
+	if err := os.Remove(worldFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("remove %s: %w", worldFile, err)
+	}
+	return current, nil
+}
+
+func runBackupCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: backup list | create | verify <name> | restore <name>")
+		fmt.Fprintln(os.Stderr, "stop the bot before restoring, otherwise the next flush overwrites the restored file")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	switch args[0] {
+	case "list":
+		backups, err := listBackups()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, b := range backups {
+			fmt.Printf("%s\t%s\t%d bytes\n", b.Name, b.CreatedAt.Local().Format("02.01.2006 15:04:05"), b.Size)
+		}
+	case "create":
+		data, err := os.ReadFile(usersFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		name, err := createBackup(withWorldFile(data), time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(name)
+	case "verify":
+		if len(args) != 2 {
+			return usage()
+		}
+		if _, err := readBackup(args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("%s: OK\n", args[1])
+	case "restore":
+		if len(args) != 2 {
+			return usage()
+		}
+		previous, err := restoreBackup(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if previous != "" {
+			fmt.Printf("Previous store saved as %s\n", previous)
+		}
+		fmt.Printf("Restored %s from %s\n", usersFile, args[1])
+	default:
+		return usage()
+	}
+	return 0
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	rewardUSD   = "usd"
+	rewardBTC   = "btc"
+	rewardGPU   = "gpu"
+	rewardBoost = "boost"
+
+	boostHashrate = "hashrate"
+	boostBusiness = "business"
+
+	bonusCalendarDays = 30
+	bonusWeekGPU      = 3
+	bonusMonthGPU     = 5
+	bonusBaseUSD      = 100
+)
+
+// BonusReward is what one day of the bonus calendar pays. Amount is USD, BTC
+// or the boost multiplier depending on Kind.
+type BonusReward struct {
+	Kind     string        `json:"kind"`
+	Amount   float64       `json:"amount,omitempty"`
+	GPUID    int           `json:"gpu_id,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// Boost is a temporary multiplier on one kind of income.
+type Boost struct {
+	Kind      string    `json:"kind"`
+	Mult      float64   `json:"mult"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// bonusReward is the reward for a day of the 30-day calendar. Odd days pay
+// BTC and even days USD, starting at about the old flat bonus and growing to
+// at most twice that by the end of the month; every 7th day alternates a
+// hashrate boost and an entry-level GPU, and day 30 pays a slightly better
+// one.
+func bonusReward(day int) BonusReward {
+	week := (day - 1) / 7
+	scale := 1 + 0.25*float64(week) + 0.05*float64((day-1)%7)
+	switch {
+	case day == bonusCalendarDays:
+		return BonusReward{Kind: rewardGPU, GPUID: bonusMonthGPU}
+	case day%7 == 0 && week%2 == 0:
+		return BonusReward{Kind: rewardBoost, Amount: 2, Duration: time.Duration(week+1) * time.Hour}
+	case day%7 == 0:
+		return BonusReward{Kind: rewardGPU, GPUID: bonusWeekGPU}
+	case day%2 == 1:
+		return BonusReward{Kind: rewardBTC, Amount: cfg.DailyBonusBTC * scale}
+	default:
+		return BonusReward{Kind: rewardUSD, Amount: bonusBaseUSD * scale}
+	}
+}
+
+func (r BonusReward) String() string {
+	switch r.Kind {
+	case rewardUSD:
+		return fmt.Sprintf("%.0f $", r.Amount)
+	case rewardBTC:
+		return fmt.Sprintf("%.5f BTC", r.Amount)
+	case rewardGPU:
+		return gpuByID[r.GPUID].Name
+	default:
+		return fmt.Sprintf("хешрейт ×%.0f на %s", r.Amount, formatPayback(r.Duration))
+	}
+}
+
+// grantBonusReward pays r to u and describes what was paid. A GPU that does
+// not fit on the farm is paid out at its catalog price instead.
+func grantBonusReward(u *User, r BonusReward, now time.Time) string {
+	switch r.Kind {
+	case rewardUSD:
+		u.BalanceUSD += r.Amount
+	case rewardBTC:
+		u.BalanceBTC += r.Amount
+	case rewardGPU:
+		g := gpuByID[r.GPUID]
+		if len(u.Inventory) >= u.FarmCapacity {
+			u.BalanceUSD += g.Price
+			return fmt.Sprintf("%.0f $ вместо %s — на ферме нет места", g.Price, g.Name)
+		}
+		u.Inventory = append(u.Inventory, newGPU(g, 0))
+	case rewardBoost:
+		addBoost(u, Boost{Kind: boostHashrate, Mult: r.Amount}, r.Duration, now)
+	}
+	return r.String()
+}
+
+// addBoost activates b for d, extending an active boost of the same kind and
+// multiplier instead of stacking it.
+func addBoost(u *User, b Boost, d time.Duration, now time.Time) {
+	for i := range u.Boosts {
+		if u.Boosts[i].Kind == b.Kind && u.Boosts[i].Mult == b.Mult && u.Boosts[i].ExpiresAt.After(now) {
+			u.Boosts[i].ExpiresAt = u.Boosts[i].ExpiresAt.Add(d)
+			return
+		}
+	}
+	b.ExpiresAt = now.Add(d)
+	u.Boosts = append(u.Boosts, b)
+}
+
+// boostMultiplier is the income multiplier from u's active boosts of kind.
+// Overlapping boosts add up: two ×2 boosts make ×3.
+func boostMultiplier(u *User, kind string, now time.Time) float64 {
+	mult := 1.0
+	for _, b := range u.Boosts {
+		if b.Kind == kind && b.ExpiresAt.After(now) {
+			mult += b.Mult - 1
+		}
+	}
+	return mult
+}
+
+// boostAverage is the mean boostMultiplier over [from, to), so that an
+// accrual spanning a boost's expiry pays the boost only for the time it was
+// active. Boosts are assumed to have started before from.
+func boostAverage(u *User, kind string, from, to time.Time) float64 {
+	span := to.Sub(from)
+	if span <= 0 {
+		return boostMultiplier(u, kind, from)
+	}
+	mult := 1.0
+	for _, b := range u.Boosts {
+		if b.Kind != kind || !b.ExpiresAt.After(from) {
+			continue
+		}
+		end := b.ExpiresAt
+		if end.After(to) {
+			end = to
+		}
+		mult += (b.Mult - 1) * float64(end.Sub(from)) / float64(span)
+	}
+	return mult
+}
+
+func pruneBoosts(u *User, now time.Time) {
+	active := u.Boosts[:0]
+	for _, b := range u.Boosts {
+		if b.ExpiresAt.After(now) {
+			active = append(active, b)
+		}
+	}
+	u.Boosts = active
+}
+
+// formatCountdown renders d as HH:MM, rounding up to the next minute.
+func formatCountdown(d time.Duration) string {
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// streakAlive reports whether claiming now continues u's streak rather than
+// starting a new one.
+func streakAlive(u *User, now time.Time) bool {
+	return u.BonusStreak > 0 && now.Sub(u.LastBonusTime) <= cfg.BonusCooldown.Duration+cfg.BonusGrace.Duration
+}
+
+func calendarDay(streak int) int {
+	return (streak-1)%bonusCalendarDays + 1
+}
+
+func claimDailyBonus(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	now := time.Now()
+	calendarKB := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📅 Календарь", "bonus_calendar"),
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+		),
+	)
+	if elapsed := now.Sub(u.LastBonusTime); elapsed < cfg.BonusCooldown.Duration {
+		text := fmt.Sprintf("🎁 Вы уже получали ежедневный бонус сегодня\n\nСледующий бонус через: %s\nСерия: %d дн.\n\n%s",
+			formatCountdown(cfg.BonusCooldown.Duration-elapsed), u.BonusStreak, currentTime)
+		sendMessageWithKeyboard(chatID, text, calendarKB)
+		return
+	}
+
+	lost := u.BonusStreak > 0 && !streakAlive(u, now)
+	if streakAlive(u, now) {
+		u.BonusStreak++
+	} else {
+		u.BonusStreak = 1
+	}
+	u.LastBonusTime = now
+	day := calendarDay(u.BonusStreak)
+	paid := grantBonusReward(u, bonusReward(day), now)
+	log.Printf("User %d claimed bonus day %d (streak %d)", u.ID, day, u.BonusStreak)
+
+	text := "🎁 *Ежедневный бонус получен!*\n\n"
+	if lost {
+		text += "Серия прервалась и началась заново\n"
+	}
+	text += fmt.Sprintf("День %d/%d: %s\n", day, bonusCalendarDays, paid)
+	text += fmt.Sprintf("Серия: %d дн.\n", u.BonusStreak)
+	text += fmt.Sprintf("Завтра: %s\n\n%s", bonusReward(calendarDay(u.BonusStreak+1)), currentTime)
+	sendMessageWithKeyboard(chatID, text, calendarKB)
+	bonusClaimed.Publish(BonusClaimed{UserID: u.ID, Day: day, Streak: u.BonusStreak})
+}
+
+func sendBonusCalendar(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	now := time.Now()
+	streak := u.BonusStreak
+	if !streakAlive(u, now) {
+		streak = 0
+	}
+	claimedToday := streak > 0 && now.Sub(u.LastBonusTime) < cfg.BonusCooldown.Duration
+	done := 0
+	if streak > 0 {
+		done = calendarDay(streak)
+	}
+	next := calendarDay(streak + 1)
+
+	text := "📅 *Календарь бонусов*\n\n"
+	for day := 1; day <= bonusCalendarDays; day++ {
+		mark := "▫️"
+		switch {
+		case day <= done && !(done == bonusCalendarDays && !claimedToday):
+			mark = "✅"
+		case day == next:
+			mark = "🎁"
+		}
+		text += mark
+		if day%7 == 0 {
+			text += "\n"
+		}
+	}
+	text += "\n\n"
+	for day := next; day < next+7 && day <= bonusCalendarDays; day++ {
+		text += fmt.Sprintf("День %d: %s\n", day, bonusReward(day))
+	}
+	text += fmt.Sprintf("\nСерия: %d дн.\n", streak)
+	switch {
+	case claimedToday:
+		text += fmt.Sprintf("Следующий бонус через %s\n", formatCountdown(cfg.BonusCooldown.Duration-now.Sub(u.LastBonusTime)))
+	case streak > 0:
+		deadline := u.LastBonusTime.Add(cfg.BonusCooldown.Duration + cfg.BonusGrace.Duration)
+		text += fmt.Sprintf("Бонус доступен! Заберите его в течение %s, иначе серия прервётся\n", formatCountdown(deadline.Sub(now)))
+	default:
+		text += "Бонус доступен!\n"
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎁 Забрать бонус", "daily_bonus"),
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+		),
+	)
+	sendMessageWithKeyboard(chatID, text, kb)
+}
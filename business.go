@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	bizMaxLevel          = 10
+	bizUpgradeCostGrowth = 1.6
+	bizIncomeGrowth      = 1.35
+
+	managerCostFactor  = 0.5
+	managerIncomeBonus = 1.25
+
+	vaultBasePeriods     = 12
+	vaultMaxLevel        = 5
+	vaultUpgradeCostRate = 0.25
+
+	currencyBTC = "BTC"
+	currencyUSD = "USD"
+
+	incomeMining = "mining"
+	incomeFees   = "fees"
+	incomeRent   = "rent"
+	incomeSpread = "spread"
+)
+
+var incomeTypeLabels = map[string]string{
+	incomeMining: "майнинг",
+	incomeFees:   "комиссии",
+	incomeRent:   "аренда",
+	incomeSpread: "торговый спред",
+}
+
+type OwnedBusiness struct {
+	ID          int       `json:"id"`
+	Level       int       `json:"level"`
+	PurchasedAt time.Time `json:"purchased_at"`
+	TotalEarned float64   `json:"total_earned"`
+	Manager     bool      `json:"manager"`
+	Vault       float64   `json:"vault"`
+	VaultLevel  int       `json:"vault_level"`
+}
+
+func findBusiness(u *User, id int) *OwnedBusiness {
+	for i := range u.Businesses {
+		if u.Businesses[i].ID == id {
+			return &u.Businesses[i]
+		}
+	}
+	return nil
+}
+
+func businessLevelIncome(b Business, level int) float64 {
+	return b.Income * math.Pow(bizIncomeGrowth, float64(level-1))
+}
+
+// businessIncome is the payout of ob per 10 minutes in its business currency.
+func businessIncome(ob OwnedBusiness) float64 {
+	b, ok := bizByID[ob.ID]
+	if !ok {
+		return 0
+	}
+	income := businessLevelIncome(b, ob.Level)
+	if ob.Manager {
+		income *= managerIncomeBonus
+	}
+	return income
+}
+
+func valueUSD(amount float64, currency string) float64 {
+	if currency == currencyBTC {
+		return amount * coinPrice(coinBTC)
+	}
+	return amount
+}
+
+func formatAmount(amount float64, currency string) string {
+	if currency == currencyBTC {
+		return fmt.Sprintf("%.7f BTC", amount)
+	}
+	return fmt.Sprintf("%.0f $", amount)
+}
+
+func creditIncome(u *User, amount float64, currency string) {
+	if currency == currencyBTC {
+		u.BalanceBTC += amount
+	} else {
+		u.BalanceUSD += amount
+	}
+}
+
+// vaultCapacity is how much income ob can hold before it stops accumulating:
+// vaultBasePeriods of 10-minute income, doubled per vault level.
+func vaultCapacity(ob OwnedBusiness) float64 {
+	return businessIncome(ob) * vaultBasePeriods * math.Pow(2, float64(ob.VaultLevel))
+}
+
+func vaultUpgradeCost(ob OwnedBusiness) float64 {
+	return bizByID[ob.ID].Price * vaultUpgradeCostRate * math.Pow(2, float64(ob.VaultLevel))
+}
+
+// accrueBusiness adds income for the given number of 10-minute periods,
+// multiplied by boost. A manager pays it out right away, but no more than a
+// full vault per accrual, so a managed business idles no longer than an
+// unmanaged one; otherwise it goes to the vault, up to its capacity.
+func accrueBusiness(u *User, ob *OwnedBusiness, periods, boost float64) {
+	earned := businessIncome(*ob) * periods * boost
+	if !ob.Manager {
+		earned = math.Max(0, math.Min(earned, vaultCapacity(*ob)-ob.Vault))
+		ob.Vault += earned
+	} else {
+		earned = math.Min(earned, vaultCapacity(*ob))
+		creditIncome(u, earned, bizByID[ob.ID].Currency)
+	}
+	ob.TotalEarned += earned
+}
+
+func vaultTotals(u *User) (btc, usd float64) {
+	for _, ob := range u.Businesses {
+		if bizByID[ob.ID].Currency == currencyBTC {
+			btc += ob.Vault
+		} else {
+			usd += ob.Vault
+		}
+	}
+	return btc, usd
+}
+
+func collectBusiness(u *User, id int, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	ob := findBusiness(u, id)
+	if ob == nil {
+		sendMessage(chatID, fmt.Sprintf("У вас нет этого бизнеса\n\n%s", currentTime))
+		return
+	}
+	biz := bizByID[id]
+	if ob.Vault <= 0 {
+		sendMessage(chatID, fmt.Sprintf("Хранилище %s пусто\n\n%s", biz.Name, currentTime))
+		return
+	}
+
+	amount := ob.Vault
+	ob.Vault = 0
+	creditIncome(u, amount, biz.Currency)
+
+	sendMessage(chatID, fmt.Sprintf("💰 *Доход собран*\n\n%s: +%s\n\n%s", biz.Name, formatAmount(amount, biz.Currency), currentTime))
+	sendBusinesses(u, chatID)
+}
+
+func collectAllBusinesses(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	btc, usd := vaultTotals(u)
+	if btc <= 0 && usd <= 0 {
+		sendMessage(chatID, fmt.Sprintf("Хранилища бизнесов пусты\n\n%s", currentTime))
+		return
+	}
+
+	for i := range u.Businesses {
+		ob := &u.Businesses[i]
+		creditIncome(u, ob.Vault, bizByID[ob.ID].Currency)
+		ob.Vault = 0
+	}
+
+	sendMessage(chatID, fmt.Sprintf("💰 *Доход собран*\n\n+%.7f BTC\n+%.0f $\n\n%s", btc, usd, currentTime))
+	sendBusinesses(u, chatID)
+}
+
+func upgradeVault(u *User, id int, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	ob := findBusiness(u, id)
+	if ob == nil {
+		sendMessage(chatID, fmt.Sprintf("У вас нет этого бизнеса\n\n%s", currentTime))
+		return
+	}
+	biz := bizByID[id]
+	if ob.VaultLevel >= vaultMaxLevel {
+		sendMessage(chatID, fmt.Sprintf("Хранилище %s уже максимального уровня\n\n%s", biz.Name, currentTime))
+		return
+	}
+	cost := vaultUpgradeCost(*ob)
+	if u.BalanceUSD < cost {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно средств: улучшение хранилища стоит %.0f $\n\n%s", cost, currentTime))
+		return
+	}
+
+	u.BalanceUSD -= cost
+	ob.VaultLevel++
+
+	sendMessage(chatID, fmt.Sprintf("🗄 *Хранилище улучшено*\n\n%s — уровень хранилища %d\nВместимость: %s\nПотрачено: %.0f $\n\n%s",
+		biz.Name, ob.VaultLevel, formatAmount(vaultCapacity(*ob), biz.Currency), cost, currentTime))
+	sendBusinessDetail(u, id, chatID)
+}
+
+func managerCost(b Business) float64 {
+	return b.Price * managerCostFactor
+}
+
+// businessInvested is the USD spent on ob so far: purchase, upgrades, vault
+// upgrades and the manager.
+func businessInvested(ob OwnedBusiness) float64 {
+	b := bizByID[ob.ID]
+	invested := b.Price
+	for level := 1; level < ob.Level; level++ {
+		invested += b.Price * math.Pow(bizUpgradeCostGrowth, float64(level))
+	}
+	for level := 0; level < ob.VaultLevel; level++ {
+		invested += b.Price * vaultUpgradeCostRate * math.Pow(2, float64(level))
+	}
+	if ob.Manager {
+		invested += managerCost(b)
+	}
+	return invested
+}
+
+// businessUpgradeCost is the price of raising ob from its current level to
+// the next one.
+func businessUpgradeCost(ob OwnedBusiness) float64 {
+	return bizByID[ob.ID].Price * math.Pow(bizUpgradeCostGrowth, float64(ob.Level))
+}
+
+func upgradeBusiness(u *User, id int, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	ob := findBusiness(u, id)
+	if ob == nil {
+		sendMessage(chatID, fmt.Sprintf("У вас нет этого бизнеса\n\n%s", currentTime))
+		return
+	}
+	biz := bizByID[id]
+	if ob.Level >= bizMaxLevel {
+		sendMessage(chatID, fmt.Sprintf("%s уже на максимальном уровне\n\n%s", biz.Name, currentTime))
+		return
+	}
+
+	cost := businessUpgradeCost(*ob)
+	if u.BalanceUSD < cost {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно средств для улучшения: нужно %.0f $\n\n%s", cost, currentTime))
+		return
+	}
+
+	u.BalanceUSD -= cost
+	ob.Level++
+
+	text := fmt.Sprintf("⬆️ *Бизнес улучшен*\n\n%s — уровень %d\nПотрачено: %.0f $\nДоход: %s/10мин\n\n%s",
+		biz.Name, ob.Level, cost, formatAmount(businessIncome(*ob), biz.Currency), currentTime)
+	sendMessage(chatID, text)
+
+	sendBusinesses(u, chatID)
+}
+
+func hireManager(u *User, id int, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	ob := findBusiness(u, id)
+	if ob == nil {
+		sendMessage(chatID, fmt.Sprintf("У вас нет этого бизнеса\n\n%s", currentTime))
+		return
+	}
+	biz := bizByID[id]
+	if ob.Manager {
+		sendMessage(chatID, fmt.Sprintf("В %s уже работает управляющий\n\n%s", biz.Name, currentTime))
+		return
+	}
+	cost := managerCost(biz)
+	if u.BalanceUSD < cost {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно средств: управляющий стоит %.0f $\n\n%s", cost, currentTime))
+		return
+	}
+
+	u.BalanceUSD -= cost
+	ob.Manager = true
+
+	text := fmt.Sprintf("👔 *Управляющий нанят*\n\n%s\nПотрачено: %.0f $\nДоход +%.0f%%, выручка зачисляется на баланс сразу, без хранилища\n\n%s",
+		biz.Name, cost, (managerIncomeBonus-1)*100, currentTime)
+	sendMessage(chatID, text)
+
+	sendBusinessDetail(u, id, chatID)
+}
+
+func sendBusinessDetail(u *User, id int, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	ob := findBusiness(u, id)
+	if ob == nil {
+		sendMessage(chatID, fmt.Sprintf("У вас нет этого бизнеса\n\n%s", currentTime))
+		return
+	}
+	biz := bizByID[id]
+	income := businessIncome(*ob)
+	incomeUSD := valueUSD(income, biz.Currency)
+	invested := businessInvested(*ob)
+
+	text := fmt.Sprintf("🏢 *%s*\n\n", biz.Name)
+	text += fmt.Sprintf("• Тип дохода: %s\n", incomeTypeLabels[biz.Type])
+	text += fmt.Sprintf("• Валюта выплат: %s\n", biz.Currency)
+	text += fmt.Sprintf("• Уровень: %d/%d\n", ob.Level, bizMaxLevel)
+	text += fmt.Sprintf("• Доход: %s / 10 мин (≈ %.0f $)\n", formatAmount(income, biz.Currency), incomeUSD)
+	text += fmt.Sprintf("• Доход в сутки: %s\n", formatAmount(income*144, biz.Currency))
+	if ob.Manager {
+		text += "• Управляющий: нанят\n"
+	} else {
+		text += fmt.Sprintf("• Управляющий: нет (%.0f $, +%.0f%% дохода и автосбор)\n", managerCost(biz), (managerIncomeBonus-1)*100)
+	}
+	if !ob.Manager {
+		text += fmt.Sprintf("• Хранилище: %s / %s (ур. %d/%d)\n",
+			formatAmount(ob.Vault, biz.Currency), formatAmount(vaultCapacity(*ob), biz.Currency), ob.VaultLevel, vaultMaxLevel)
+	}
+	text += fmt.Sprintf("• Вложено: %.0f $\n", invested)
+	text += fmt.Sprintf("• Заработано: %s\n", formatAmount(ob.TotalEarned, biz.Currency))
+	if incomeUSD > 0 {
+		text += fmt.Sprintf("• ROI: %.1f%% в сутки\n", incomeUSD*144/invested*100)
+		payback := time.Duration(invested / incomeUSD * float64(10*time.Minute))
+		text += fmt.Sprintf("• Окупаемость: %s\n", formatPayback(payback))
+	}
+	if ob.Level < bizMaxLevel {
+		cost := businessUpgradeCost(*ob)
+		gain := valueUSD(businessLevelIncome(biz, ob.Level+1)-businessLevelIncome(biz, ob.Level), biz.Currency)
+		if ob.Manager {
+			gain *= managerIncomeBonus
+		}
+		text += fmt.Sprintf("• Улучшение до ур. %d: %.0f $, окупится за %s\n", ob.Level+1, cost, formatPayback(time.Duration(cost/gain*float64(10*time.Minute))))
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+
+	var kbRows [][]tgbotapi.InlineKeyboardButton
+	var actions []tgbotapi.InlineKeyboardButton
+	if ob.Level < bizMaxLevel {
+		actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("⬆️ Улучшить (%.0f $)", businessUpgradeCost(*ob)), fmt.Sprintf("upgrade_biz:%d", id)))
+	}
+	if !ob.Manager {
+		actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("👔 Нанять (%.0f $)", managerCost(biz)), fmt.Sprintf("hire_manager:%d", id)))
+	}
+	if len(actions) > 0 {
+		kbRows = append(kbRows, actions)
+	}
+	if !ob.Manager {
+		var vaultRow []tgbotapi.InlineKeyboardButton
+		if ob.Vault > 0 {
+			vaultRow = append(vaultRow, tgbotapi.NewInlineKeyboardButtonData("💰 Собрать", fmt.Sprintf("collect_biz:%d", id)))
+		}
+		if ob.VaultLevel < vaultMaxLevel {
+			vaultRow = append(vaultRow, tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("🗄 Хранилище (%.0f $)", vaultUpgradeCost(*ob)), fmt.Sprintf("upgrade_vault:%d", id)))
+		}
+		if len(vaultRow) > 0 {
+			kbRows = append(kbRows, vaultRow)
+		}
+	}
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "business"),
+	))
+
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
+
+func formatPayback(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%.0f мин", d.Minutes())
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%.1f ч", d.Hours())
+	default:
+		return fmt.Sprintf("%.1f дн", d.Hours()/24)
+	}
+}
+
+func businessRows(u *User) [][]tgbotapi.InlineKeyboardButton {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, ob := range u.Businesses {
+		biz, ok := bizByID[ob.ID]
+		if !ok {
+			continue
+		}
+		row := tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("ℹ️ "+biz.Name, fmt.Sprintf("biz:%d", ob.ID)),
+		)
+		if ob.Vault > 0 {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("💰 Собрать", fmt.Sprintf("collect_biz:%d", ob.ID)))
+		}
+		if ob.Level < bizMaxLevel {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("⬆️ ур. %d (%.0f $)", ob.Level+1, businessUpgradeCost(ob)),
+				fmt.Sprintf("upgrade_biz:%d", ob.ID),
+			))
+		}
+		rows = append(rows, row)
+	}
+	if btc, usd := vaultTotals(u); btc > 0 || usd > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💰 Собрать всё", "collect_all"),
+		))
+	}
+	return rows
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	roleLeader  = "leader"
+	roleOfficer = "officer"
+	roleMember  = "member"
+
+	clanMaxLevel          = 10
+	clanBaseMembers       = 10
+	clanMembersPerLevel   = 2
+	clanBonusPerLevel     = 0.02
+	clanUpgradeBaseCost   = 25000
+	clanUpgradeCostGrowth = 1.8
+	clanLeaderboardSize   = 10
+)
+
+var roleLabels = map[string]string{
+	roleLeader:  "👑 лидер",
+	roleOfficer: "⭐ офицер",
+	roleMember:  "участник",
+}
+
+type ClanMember struct {
+	UserID   int64     `json:"user_id"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Clan is a mining guild. Its treasury holds USD donated by members and is
+// spent on levels, each of which raises the mining output of every member.
+type Clan struct {
+	ID        int64        `json:"id"`
+	Name      string       `json:"name"`
+	Tag       string       `json:"tag"`
+	Members   []ClanMember `json:"members"`
+	Treasury  float64      `json:"treasury"`
+	Level     int          `json:"level"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+func userClan(u *User) *Clan {
+	if u.ClanID == 0 {
+		return nil
+	}
+	return store.Clans[u.ClanID]
+}
+
+func findClanByTag(tag string) *Clan {
+	for _, c := range store.Clans {
+		if strings.EqualFold(c.Tag, tag) {
+			return c
+		}
+	}
+	return nil
+}
+
+func clanMember(c *Clan, id int64) *ClanMember {
+	for i := range c.Members {
+		if c.Members[i].UserID == id {
+			return &c.Members[i]
+		}
+	}
+	return nil
+}
+
+func clanRole(c *Clan, id int64) string {
+	if m := clanMember(c, id); m != nil {
+		return m.Role
+	}
+	return ""
+}
+
+func clanHashrate(c *Clan) float64 {
+	var rate float64
+	for _, m := range c.Members {
+		if u, ok := store.Users[m.UserID]; ok {
+			rate += totalMiningRate(u)
+		}
+	}
+	return rate
+}
+
+func clanCapacity(c *Clan) int {
+	return clanBaseMembers + clanMembersPerLevel*c.Level
+}
+
+func clanUpgradeCost(c *Clan) float64 {
+	return clanUpgradeBaseCost * math.Pow(clanUpgradeCostGrowth, float64(c.Level))
+}
+
+// clanMiningBonus is the extra share of mining output u gets from its clan.
+func clanMiningBonus(u *User) float64 {
+	if c := userClan(u); c != nil {
+		return float64(c.Level) * clanBonusPerLevel
+	}
+	return 0
+}
+
+func validClanTag(tag string) bool {
+	if n := utf8.RuneCountInString(tag); n < 2 || n > 5 {
+		return false
+	}
+	for _, r := range tag {
+		if !unicode.IsUpper(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// validClanName allows letters, digits, spaces and hyphens only, so names
+// cannot break Markdown formatting.
+func validClanName(name string) bool {
+	if n := utf8.RuneCountInString(name); n < 3 || n > 32 {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func createClan(u *User, parts []string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if len(parts) < 3 {
+		sendMessage(chatID, fmt.Sprintf("Используйте: /clan_create [ТЕГ] [название]\n\n%s", currentTime))
+		return
+	}
+	tag := strings.ToUpper(parts[1])
+	name := strings.TrimSpace(strings.Join(parts[2:], " "))
+	if userClan(u) != nil {
+		sendMessage(chatID, fmt.Sprintf("Вы уже состоите в клане\n\n%s", currentTime))
+		return
+	}
+	if !validClanTag(tag) || !validClanName(name) {
+		sendMessage(chatID, fmt.Sprintf("Тег — 2–5 заглавных букв или цифр, название — 3–32 символа из букв, цифр, пробелов и дефисов\n\n%s", currentTime))
+		return
+	}
+	if findClanByTag(tag) != nil {
+		sendMessage(chatID, fmt.Sprintf("Клан с тегом [%s] уже существует\n\n%s", tag, currentTime))
+		return
+	}
+	for _, c := range store.Clans {
+		if strings.EqualFold(c.Name, name) {
+			sendMessage(chatID, fmt.Sprintf("Клан с таким названием уже существует\n\n%s", currentTime))
+			return
+		}
+	}
+	if u.BalanceUSD < cfg.ClanCreateCost {
+		sendMessage(chatID, fmt.Sprintf("Создание клана стоит %.0f $\n\n%s", cfg.ClanCreateCost, currentTime))
+		return
+	}
+
+	u.BalanceUSD -= cfg.ClanCreateCost
+	store.NextClanID++
+	now := time.Now()
+	c := &Clan{
+		ID:        store.NextClanID,
+		Name:      name,
+		Tag:       tag,
+		Members:   []ClanMember{{UserID: u.ID, Role: roleLeader, JoinedAt: now}},
+		CreatedAt: now,
+	}
+	if store.Clans == nil {
+		store.Clans = map[int64]*Clan{}
+	}
+	store.Clans[c.ID] = c
+	u.ClanID = c.ID
+	markStoreDirty()
+	log.Printf("User %d created clan %d [%s]", u.ID, c.ID, c.Tag)
+	defer checkAchievements(u)
+
+	sendMessage(chatID, fmt.Sprintf("👥 Клан [%s] %s создан!\n\n%s", c.Tag, c.Name, currentTime))
+	sendClan(u, chatID)
+}
+
+func joinClan(u *User, parts []string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if len(parts) != 2 {
+		sendMessage(chatID, fmt.Sprintf("Используйте: /clan_join [ТЕГ]\n\n%s", currentTime))
+		return
+	}
+	if userClan(u) != nil {
+		sendMessage(chatID, fmt.Sprintf("Вы уже состоите в клане. Сначала покиньте его\n\n%s", currentTime))
+		return
+	}
+	c := findClanByTag(parts[1])
+	if c == nil {
+		sendMessage(chatID, fmt.Sprintf("Клан [%s] не найден\n\n%s", strings.ToUpper(parts[1]), currentTime))
+		return
+	}
+	if len(c.Members) >= clanCapacity(c) {
+		sendMessage(chatID, fmt.Sprintf("В клане [%s] нет свободных мест\n\n%s", c.Tag, currentTime))
+		return
+	}
+
+	c.Members = append(c.Members, ClanMember{UserID: u.ID, Role: roleMember, JoinedAt: time.Now()})
+	u.ClanID = c.ID
+	markStoreDirty()
+	defer checkAchievements(u)
+	sendMessage(chatID, fmt.Sprintf("👥 Вы вступили в клан [%s] %s\n\n%s", c.Tag, c.Name, currentTime))
+	sendClan(u, chatID)
+}
+
+// removeClanMember drops id from c. A departing leader hands over to the
+// longest-serving officer, or else member; an empty clan is disbanded together
+// with its treasury.
+func removeClanMember(c *Clan, id int64) {
+	var role string
+	for i, m := range c.Members {
+		if m.UserID == id {
+			role = m.Role
+			c.Members = append(c.Members[:i], c.Members[i+1:]...)
+			break
+		}
+	}
+	if u, ok := store.Users[id]; ok {
+		u.ClanID = 0
+		markDirty(u)
+	}
+	markStoreDirty()
+	if len(c.Members) == 0 {
+		delete(store.Clans, c.ID)
+		log.Printf("Clan %d [%s] disbanded", c.ID, c.Tag)
+		return
+	}
+	if role == roleLeader {
+		heir := 0
+		for i, m := range c.Members {
+			if m.Role == roleOfficer && c.Members[heir].Role != roleOfficer {
+				heir = i
+			}
+		}
+		c.Members[heir].Role = roleLeader
+		queueNotice(c.Members[heir].UserID, fmt.Sprintf("👑 Вы стали лидером клана [%s]", c.Tag))
+	}
+}
+
+func leaveClan(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	c := userClan(u)
+	if c == nil {
+		sendMessage(chatID, fmt.Sprintf("Вы не состоите в клане\n\n%s", currentTime))
+		return
+	}
+	removeClanMember(c, u.ID)
+	sendMessage(chatID, fmt.Sprintf("Вы покинули клан [%s]\n\n%s", c.Tag, currentTime))
+}
+
+func donateToClan(u *User, parts []string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	c := userClan(u)
+	if c == nil {
+		sendMessage(chatID, fmt.Sprintf("Вы не состоите в клане\n\n%s", currentTime))
+		return
+	}
+	var amount float64
+	if len(parts) == 2 {
+		amount, _ = strconv.ParseFloat(parts[1], 64)
+	}
+	if !(amount > 0) {
+		sendMessage(chatID, fmt.Sprintf("Используйте: /clan_donate [сумма в $]\n\n%s", currentTime))
+		return
+	}
+	if u.BalanceUSD < amount {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно средств\n\n%s", currentTime))
+		return
+	}
+
+	u.BalanceUSD -= amount
+	c.Treasury += amount
+	markStoreDirty()
+	sendMessage(chatID, fmt.Sprintf("💰 Вы внесли %.0f $ в казну клана [%s]. В казне: %.0f $\n\n%s", amount, c.Tag, c.Treasury, currentTime))
+}
+
+func upgradeClan(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	c := userClan(u)
+	if c == nil {
+		sendMessage(chatID, fmt.Sprintf("Вы не состоите в клане\n\n%s", currentTime))
+		return
+	}
+	if role := clanRole(c, u.ID); role != roleLeader && role != roleOfficer {
+		sendMessage(chatID, fmt.Sprintf("Улучшать клан могут только лидер и офицеры\n\n%s", currentTime))
+		return
+	}
+	if c.Level >= clanMaxLevel {
+		sendMessage(chatID, fmt.Sprintf("Клан уже максимального уровня\n\n%s", currentTime))
+		return
+	}
+	cost := clanUpgradeCost(c)
+	if c.Treasury < cost {
+		sendMessage(chatID, fmt.Sprintf("В казне недостаточно средств: нужно %.0f $\n\n%s", cost, currentTime))
+		return
+	}
+
+	c.Treasury -= cost
+	c.Level++
+	markStoreDirty()
+	sendMessage(chatID, fmt.Sprintf("⬆️ Клан [%s] достиг уровня %d! Бонус к майнингу: +%.0f%%\n\n%s", c.Tag, c.Level, float64(c.Level)*clanBonusPerLevel*100, currentTime))
+	sendClan(u, chatID)
+}
+
+// manageClanMember applies a leader or officer action to another member:
+// "kick" (officers may kick plain members only), "promote" and "demote"
+// (leader only).
+func manageClanMember(u *User, action string, parts []string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	c := userClan(u)
+	if c == nil {
+		sendMessage(chatID, fmt.Sprintf("Вы не состоите в клане\n\n%s", currentTime))
+		return
+	}
+	if len(parts) != 2 {
+		sendMessage(chatID, fmt.Sprintf("Используйте: %s @username\n\n%s", parts[0], currentTime))
+		return
+	}
+	target, err := findUser(store, parts[1])
+	if err != nil || target.ClanID != c.ID || target.ID == u.ID {
+		sendMessage(chatID, fmt.Sprintf("Игрок %s не найден среди участников клана\n\n%s", parts[1], currentTime))
+		return
+	}
+	role, targetRole := clanRole(c, u.ID), clanRole(c, target.ID)
+	allowed := role == roleLeader || (action == "kick" && role == roleOfficer && targetRole == roleMember)
+	if !allowed {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно прав\n\n%s", currentTime))
+		return
+	}
+
+	switch action {
+	case "kick":
+		removeClanMember(c, target.ID)
+		queueNotice(target.ID, fmt.Sprintf("Вас исключили из клана [%s]", c.Tag))
+	case "promote":
+		clanMember(c, target.ID).Role = roleOfficer
+	case "demote":
+		clanMember(c, target.ID).Role = roleMember
+	}
+	markStoreDirty()
+	sendClan(u, chatID)
+}
+
+func sendClan(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	c := userClan(u)
+	if c == nil {
+		text := "👥 *Кланы*\n\n"
+		text += "Вы не состоите в клане. Участники клана получают бонус к майнингу за каждый уровень клана, а уровни покупаются из общей казны.\n\n"
+		text += fmt.Sprintf("/clan_create [ТЕГ] [название] — создать клан (%.0f $)\n", cfg.ClanCreateCost)
+		text += "/clan_join [ТЕГ] — вступить в клан\n"
+		text += fmt.Sprintf("\n%s", currentTime)
+		kb := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🏆 Рейтинг кланов", "clan_top"),
+				tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+			),
+		)
+		sendMessageWithKeyboard(chatID, text, kb)
+		return
+	}
+
+	members := append([]ClanMember(nil), c.Members...)
+	roleOrder := map[string]int{roleLeader: 0, roleOfficer: 1, roleMember: 2}
+	sort.SliceStable(members, func(i, j int) bool { return roleOrder[members[i].Role] < roleOrder[members[j].Role] })
+
+	text := fmt.Sprintf("👥 *[%s] %s*\n\n", c.Tag, c.Name)
+	text += fmt.Sprintf("• Уровень: %d/%d, бонус к майнингу +%.0f%%\n", c.Level, clanMaxLevel, float64(c.Level)*clanBonusPerLevel*100)
+	text += fmt.Sprintf("• Хешрейт клана: %.7f BTC/10мин\n", clanHashrate(c))
+	text += fmt.Sprintf("• Казна: %.0f $\n", c.Treasury)
+	if c.Level < clanMaxLevel {
+		text += fmt.Sprintf("• Следующий уровень: %.0f $\n", clanUpgradeCost(c))
+	}
+	text += fmt.Sprintf("• Участники: %d/%d\n\n", len(c.Members), clanCapacity(c))
+	for _, m := range members {
+		text += fmt.Sprintf("@%s — %s\n", usernameOf(m.UserID), roleLabels[m.Role])
+	}
+	text += "\n/clan_donate [сумма] — пополнить казну"
+	if role := clanRole(c, u.ID); role == roleLeader || role == roleOfficer {
+		text += "\n/clan_kick @username — исключить"
+	}
+	if clanRole(c, u.ID) == roleLeader {
+		text += "\n/clan_promote, /clan_demote @username — назначить или снять офицера"
+	}
+	text += fmt.Sprintf("\n\n%s", currentTime)
+
+	kbRows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	if role := clanRole(c, u.ID); (role == roleLeader || role == roleOfficer) && c.Level < clanMaxLevel {
+		kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("⬆️ Уровень %d (%.0f $)", c.Level+1, clanUpgradeCost(c)), "clan_upgrade"),
+		))
+	}
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🏆 Рейтинг кланов", "clan_top"),
+		tgbotapi.NewInlineKeyboardButtonData("🚪 Покинуть", "clan_leave"),
+	))
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+	))
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
+
+func sendClanLeaderboard(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	clans := make([]*Clan, 0, len(store.Clans))
+	rates := map[int64]float64{}
+	for _, c := range store.Clans {
+		clans = append(clans, c)
+		rates[c.ID] = clanHashrate(c)
+	}
+	sort.Slice(clans, func(i, j int) bool {
+		if rates[clans[i].ID] != rates[clans[j].ID] {
+			return rates[clans[i].ID] > rates[clans[j].ID]
+		}
+		return clans[i].ID < clans[j].ID
+	})
+
+	text := "🏆 *Рейтинг кланов*\n\n"
+	if len(clans) == 0 {
+		text += "Кланов пока нет\n"
+	}
+	for i, c := range clans[:min(len(clans), clanLeaderboardSize)] {
+		text += fmt.Sprintf("%d. [%s] %s — %.7f BTC/10мин, ур. %d, %d чел.\n", i+1, c.Tag, c.Name, rates[c.ID], c.Level, len(c.Members))
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "clan"),
+		),
+	)
+	sendMessageWithKeyboard(chatID, text, kb)
+}
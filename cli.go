@@ -0,0 +1,792 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const cliUsage = `usage: TgPlotter [command]
+
+Commands:
+  serve                              run the Telegram bot (default)
+  users list [-sort usd|btc|rate|created|prestige] [-limit N]
+  users show <id|@username>
+  users edit <id|@username> field=value...
+  store validate
+  store export [-format json|csv] [-o file]
+  catalog lint
+  economy simulate [-days N] [-start-usd N]
+  backup list | create | verify <name> | restore <name>
+
+All commands except serve work on data files directly and do not need
+TELEGRAM_BOT_TOKEN. Stop the bot before running commands that modify data.
+`
+
+func runCLI(args []string) int {
+	c, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config: %v\n", err)
+		return 1
+	}
+	cfg = c
+	if cfg.RandomSeed != 0 {
+		rng = rand.New(rand.NewSource(cfg.RandomSeed))
+	}
+
+	if len(args) == 0 {
+		serve()
+		return 0
+	}
+	switch args[0] {
+	case "serve":
+		serve()
+		return 0
+	case "users":
+		return runUsersCommand(args[1:])
+	case "store":
+		return runStoreCommand(args[1:])
+	case "catalog":
+		return runCatalogCommand(args[1:])
+	case "economy":
+		return runEconomyCommand(args[1:])
+	case "backup":
+		return runBackupCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(cliUsage)
+		return 0
+	default:
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+}
+
+func cliFail(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
+
+// loadStoreForCLI also installs the store globally so that coin prices come
+// from the stored markets.
+func loadStoreForCLI() (Store, error) {
+	initCatalogs()
+	s, err := readStoreFile()
+	if err == nil {
+		store = s
+	}
+	return s, err
+}
+
+func findUser(s Store, ref string) (*User, error) {
+	if name, ok := strings.CutPrefix(ref, "@"); ok {
+		for _, u := range s.Users {
+			if strings.EqualFold(u.Username, name) {
+				return u, nil
+			}
+		}
+		return nil, fmt.Errorf("user @%s not found", name)
+	}
+	id, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user reference %q", ref)
+	}
+	u, ok := s.Users[id]
+	if !ok {
+		return nil, fmt.Errorf("user %d not found", id)
+	}
+	return u, nil
+}
+
+func sortedUsers(s Store) []*User {
+	users := make([]*User, 0, len(s.Users))
+	for _, u := range s.Users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+func runUsersCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	switch args[0] {
+	case "list":
+		return usersList(args[1:])
+	case "show":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, cliUsage)
+			return 2
+		}
+		return usersShow(args[1])
+	case "edit":
+		if len(args) < 3 {
+			fmt.Fprint(os.Stderr, cliUsage)
+			return 2
+		}
+		return usersEdit(args[1], args[2:])
+	default:
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+}
+
+func usersList(args []string) int {
+	fs := flag.NewFlagSet("users list", flag.ContinueOnError)
+	sortBy := fs.String("sort", "usd", "sort by usd, btc, rate, created or prestige")
+	limit := fs.Int("limit", 0, "show at most N users (0 = all)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	s, err := loadStoreForCLI()
+	if err != nil {
+		return cliFail(err)
+	}
+	users := sortedUsers(s)
+	var less func(a, b *User) bool
+	switch *sortBy {
+	case "usd":
+		less = func(a, b *User) bool { return a.BalanceUSD > b.BalanceUSD }
+	case "btc":
+		less = func(a, b *User) bool { return a.BalanceBTC > b.BalanceBTC }
+	case "rate":
+		less = func(a, b *User) bool { return totalIncomeUSD(a) > totalIncomeUSD(b) }
+	case "created":
+		less = func(a, b *User) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case "prestige":
+		less = func(a, b *User) bool { return a.Prestige > b.Prestige }
+	default:
+		return cliFail(fmt.Errorf("unknown sort key %q", *sortBy))
+	}
+	sort.SliceStable(users, func(i, j int) bool { return less(users[i], users[j]) })
+	if *limit > 0 && len(users) > *limit {
+		users = users[:*limit]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tBTC\tUSD\tGPUS\tBUSINESSES\tINCOME $/10MIN\tPRESTIGE\tCREATED")
+	for _, u := range users {
+		fmt.Fprintf(w, "%d\t@%s\t%.7f\t%.2f\t%d\t%d\t%.2f\t%d\t%s\n",
+			u.ID, u.Username, u.BalanceBTC, u.BalanceUSD, len(u.Inventory), len(u.Businesses),
+			totalIncomeUSD(u), u.Prestige, u.CreatedAt.Format("02.01.2006"))
+	}
+	w.Flush()
+	return 0
+}
+
+func usersShow(ref string) int {
+	s, err := loadStoreForCLI()
+	if err != nil {
+		return cliFail(err)
+	}
+	u, err := findUser(s, ref)
+	if err != nil {
+		return cliFail(err)
+	}
+
+	fmt.Printf("ID:              %d\n", u.ID)
+	fmt.Printf("Username:        @%s\n", u.Username)
+	fmt.Printf("Balance BTC:     %.8f\n", u.BalanceBTC)
+	fmt.Printf("Balance USD:     %.2f\n", u.BalanceUSD)
+	fmt.Printf("Farm:            %d/%d\n", len(u.Inventory), u.FarmCapacity)
+	fmt.Printf("Mining rate:     %.7f BTC/10min hashrate, %s/10min after fees (pool %s)\n", totalMiningRate(u), formatYields(miningYields(u)), miningPool(u.Pool).ID)
+	for _, symbol := range walletSymbols(u) {
+		fmt.Printf("Balance %-9s%s\n", symbol+":", formatCoin(u.Wallet[symbol], symbol))
+	}
+	fmt.Printf("Business income: %.7f BTC + %.2f USD/10min\n", totalBusinessIncome(u, currencyBTC), totalBusinessIncome(u, currencyUSD))
+	fmt.Printf("Created:         %s\n", u.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("Last accrual:    %s\n", u.LastAccrualAt.Format(time.RFC3339))
+	fmt.Printf("Last bonus:      %s (streak %d)\n", u.LastBonusTime.Format(time.RFC3339), u.BonusStreak)
+	fmt.Printf("Mined BTC:       %.7f\n", u.MinedBTC)
+	fmt.Printf("Prestige:        level %d, %d points (x%.2f income)\n", u.Prestige, u.PrestigePoints, prestigeMultiplier(u))
+	fmt.Printf("Achievements:    %d/%d\n", len(u.Achievements), len(achievementCatalog))
+	for _, c := range consumableCatalog {
+		if n := u.Items[c.ID]; n > 0 {
+			fmt.Printf("Item:            %s x%d\n", c.ID, n)
+		}
+	}
+	for _, b := range u.Boosts {
+		fmt.Printf("Boost:           %s x%.1f until %s\n", b.Kind, b.Mult, b.ExpiresAt.Format(time.RFC3339))
+	}
+
+	counts := map[int]int{}
+	broken := map[int]int{}
+	for _, card := range u.Inventory {
+		counts[card.GPUID]++
+		if card.Broken {
+			broken[card.GPUID]++
+		}
+	}
+	ids := make([]int, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	fmt.Println("GPUs:")
+	for _, id := range ids {
+		fmt.Printf("  %3d x%-3d %s (%d broken)\n", id, counts[id], gpuByID[id].Name, broken[id])
+	}
+	fmt.Println("Businesses:")
+	for _, ob := range u.Businesses {
+		biz := bizByID[ob.ID]
+		fmt.Printf("  %3d %s, level %d, manager %t, vault %.7f %s (level %d), earned %.7f %s, bought %s\n",
+			ob.ID, biz.Name, ob.Level, ob.Manager, ob.Vault, biz.Currency, ob.VaultLevel, ob.TotalEarned, biz.Currency, ob.PurchasedAt.Format("02.01.2006"))
+	}
+	return 0
+}
+
+func usersEdit(ref string, assignments []string) int {
+	s, err := loadStoreForCLI()
+	if err != nil {
+		return cliFail(err)
+	}
+	u, err := findUser(s, ref)
+	if err != nil {
+		return cliFail(err)
+	}
+
+	for _, a := range assignments {
+		field, value, ok := strings.Cut(a, "=")
+		if !ok {
+			return cliFail(fmt.Errorf("expected field=value, got %q", a))
+		}
+		switch field {
+		case "username":
+			u.Username = strings.TrimPrefix(value, "@")
+		case "balance_btc", "balance_usd":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+				return cliFail(fmt.Errorf("invalid %s %q", field, value))
+			}
+			if field == "balance_btc" {
+				u.BalanceBTC = f
+			} else {
+				u.BalanceUSD = f
+			}
+		case "farm_capacity":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return cliFail(fmt.Errorf("invalid farm_capacity %q", value))
+			}
+			u.FarmCapacity = n
+		default:
+			return cliFail(fmt.Errorf("field %q is not editable (username, balance_btc, balance_usd, farm_capacity)", field))
+		}
+	}
+
+	data, err := os.ReadFile(usersFile)
+	if err != nil {
+		return cliFail(err)
+	}
+	backup, err := createBackup(data, time.Now())
+	if err != nil {
+		return cliFail(fmt.Errorf("back up store: %w", err))
+	}
+	store = s
+	if err := writeStoreFile(); err != nil {
+		return cliFail(err)
+	}
+	fmt.Printf("Updated user %d (previous store saved as %s)\n", u.ID, backup)
+	return 0
+}
+
+func runStoreCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	switch args[0] {
+	case "validate":
+		return storeValidate()
+	case "export":
+		return storeExport(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+}
+
+func validateStore(s Store) []string {
+	var issues []string
+	badAmount := func(f float64) bool { return f < 0 || math.IsNaN(f) || math.IsInf(f, 0) }
+	usernames := map[string]int64{}
+	instances := map[int64]int64{}
+	for _, u := range sortedUsers(s) {
+		if badAmount(u.BalanceBTC) {
+			issues = append(issues, fmt.Sprintf("user %d: invalid balance_btc %v", u.ID, u.BalanceBTC))
+		}
+		if badAmount(u.BalanceUSD) {
+			issues = append(issues, fmt.Sprintf("user %d: invalid balance_usd %v", u.ID, u.BalanceUSD))
+		}
+		for symbol, amount := range u.Wallet {
+			if !validCoin(symbol) || symbol == coinBTC {
+				issues = append(issues, fmt.Sprintf("user %d: wallet holds unknown coin %q", u.ID, symbol))
+			}
+			if badAmount(amount) {
+				issues = append(issues, fmt.Sprintf("user %d: invalid %s balance %v", u.ID, symbol, amount))
+			}
+		}
+		if u.MiningCoin != "" && !validCoin(u.MiningCoin) {
+			issues = append(issues, fmt.Sprintf("user %d: mines unknown coin %q", u.ID, u.MiningCoin))
+		}
+		if u.Pool != "" && !validPool(u.Pool) {
+			issues = append(issues, fmt.Sprintf("user %d: unknown pool %q", u.ID, u.Pool))
+		}
+		if u.FarmCapacity > 0 && len(u.Inventory) > u.FarmCapacity {
+			issues = append(issues, fmt.Sprintf("user %d: %d GPUs exceed farm capacity %d", u.ID, len(u.Inventory), u.FarmCapacity))
+		}
+		for id := range u.Achievements {
+			if !validAchievement(id) {
+				issues = append(issues, fmt.Sprintf("user %d: unknown achievement %q", u.ID, id))
+			}
+		}
+		for _, q := range u.Quests {
+			if _, ok := questTemplate(q.Kind); !ok || !(q.Goal > 0) {
+				issues = append(issues, fmt.Sprintf("user %d: invalid quest %q with goal %v", u.ID, q.Kind, q.Goal))
+			}
+		}
+		for id, n := range u.Items {
+			if _, ok := findConsumable(id); !ok || n < 0 {
+				issues = append(issues, fmt.Sprintf("user %d: invalid item %q x%d", u.ID, id, n))
+			}
+		}
+		for id, n := range u.ItemsBought {
+			if c, ok := findConsumable(id); !ok || n < 0 || n > c.DailyLimit {
+				issues = append(issues, fmt.Sprintf("user %d: invalid daily purchases of item %q: %d", u.ID, id, n))
+			}
+		}
+		if u.Prestige < 0 || u.PrestigePoints < 0 {
+			issues = append(issues, fmt.Sprintf("user %d: negative prestige %d/%d", u.ID, u.Prestige, u.PrestigePoints))
+		}
+		if u.BonusStreak < 0 {
+			issues = append(issues, fmt.Sprintf("user %d: negative bonus streak %d", u.ID, u.BonusStreak))
+		}
+		for _, b := range u.Boosts {
+			if !(b.Mult > 0) || math.IsInf(b.Mult, 0) {
+				issues = append(issues, fmt.Sprintf("user %d: invalid %s boost multiplier %v", u.ID, b.Kind, b.Mult))
+			}
+		}
+		for _, card := range u.Inventory {
+			if _, ok := gpuByID[card.GPUID]; !ok {
+				issues = append(issues, fmt.Sprintf("user %d: unknown GPU %d", u.ID, card.GPUID))
+			}
+			if owner, ok := instances[card.InstanceID]; ok {
+				issues = append(issues, fmt.Sprintf("user %d: GPU instance %d also owned by %d", u.ID, card.InstanceID, owner))
+			}
+			instances[card.InstanceID] = u.ID
+			if card.InstanceID <= 0 || card.InstanceID > s.NextGPUID {
+				issues = append(issues, fmt.Sprintf("user %d: GPU instance id %d outside 1..%d", u.ID, card.InstanceID, s.NextGPUID))
+			}
+			if card.Profile != "" && !validProfile(card.Profile) {
+				issues = append(issues, fmt.Sprintf("user %d: GPU instance %d has unknown profile %q", u.ID, card.InstanceID, card.Profile))
+			}
+			if card.Coin != "" && !validCoin(card.Coin) {
+				issues = append(issues, fmt.Sprintf("user %d: GPU instance %d mines unknown coin %q", u.ID, card.InstanceID, card.Coin))
+			}
+			if card.Durability < 0 || card.Durability > maxDurability {
+				issues = append(issues, fmt.Sprintf("user %d: GPU %d has durability %v", u.ID, card.GPUID, card.Durability))
+			}
+		}
+		owned := map[int]bool{}
+		for _, ob := range u.Businesses {
+			if _, ok := bizByID[ob.ID]; !ok {
+				issues = append(issues, fmt.Sprintf("user %d: unknown business %d", u.ID, ob.ID))
+			}
+			if owned[ob.ID] {
+				issues = append(issues, fmt.Sprintf("user %d: business %d owned twice", u.ID, ob.ID))
+			}
+			if ob.Level < 1 || ob.Level > bizMaxLevel {
+				issues = append(issues, fmt.Sprintf("user %d: business %d has invalid level %d", u.ID, ob.ID, ob.Level))
+			}
+			if ob.VaultLevel < 0 || ob.VaultLevel > vaultMaxLevel || badAmount(ob.Vault) {
+				issues = append(issues, fmt.Sprintf("user %d: business %d has invalid vault %v (level %d)", u.ID, ob.ID, ob.Vault, ob.VaultLevel))
+			}
+			owned[ob.ID] = true
+		}
+		if u.Username != "" {
+			name := strings.ToLower(u.Username)
+			if other, ok := usernames[name]; ok {
+				issues = append(issues, fmt.Sprintf("user %d: username @%s also used by %d", u.ID, u.Username, other))
+			}
+			usernames[name] = u.ID
+		}
+	}
+	if d := s.Network.Difficulty; d != 0 && (d < 1 || math.IsNaN(d) || math.IsInf(d, 0)) {
+		issues = append(issues, fmt.Sprintf("network difficulty %v is below 1", d))
+	}
+	if s.Network.Height < 0 {
+		issues = append(issues, fmt.Sprintf("negative block height %d", s.Network.Height))
+	}
+	for symbol, m := range s.Coins {
+		if !validCoin(symbol) {
+			issues = append(issues, fmt.Sprintf("market for unknown coin %q", symbol))
+		}
+		if m.Price <= 0 || math.IsNaN(m.Price) || math.IsInf(m.Price, 0) {
+			issues = append(issues, fmt.Sprintf("coin %s has invalid price %v", symbol, m.Price))
+		}
+	}
+	for _, l := range s.Listings {
+		if _, ok := s.Users[l.Seller]; !ok {
+			issues = append(issues, fmt.Sprintf("listing %d: unknown seller %d", l.ID, l.Seller))
+		}
+		if _, ok := gpuByID[l.Card.GPUID]; !ok {
+			issues = append(issues, fmt.Sprintf("listing %d: unknown GPU %d", l.ID, l.Card.GPUID))
+		}
+		if owner, ok := instances[l.Card.InstanceID]; ok {
+			issues = append(issues, fmt.Sprintf("listing %d: GPU instance %d also owned by %d", l.ID, l.Card.InstanceID, owner))
+		}
+		instances[l.Card.InstanceID] = l.Seller
+		if !(l.Price > 0) || l.ID <= 0 || l.ID > s.NextListingID {
+			issues = append(issues, fmt.Sprintf("listing %d: invalid id or price %v", l.ID, l.Price))
+		}
+	}
+	tags := map[string]int64{}
+	for id, c := range s.Clans {
+		if c.ID != id {
+			issues = append(issues, fmt.Sprintf("clan key %d holds record with id %d", id, c.ID))
+		}
+		if other, ok := tags[strings.ToUpper(c.Tag)]; ok {
+			issues = append(issues, fmt.Sprintf("clan %d: tag [%s] also used by clan %d", c.ID, c.Tag, other))
+		}
+		tags[strings.ToUpper(c.Tag)] = c.ID
+		leaders := 0
+		for _, m := range c.Members {
+			if m.Role == roleLeader {
+				leaders++
+			}
+			if _, ok := roleLabels[m.Role]; !ok {
+				issues = append(issues, fmt.Sprintf("clan %d: member %d has unknown role %q", c.ID, m.UserID, m.Role))
+			}
+			if u, ok := s.Users[m.UserID]; !ok || u.ClanID != c.ID {
+				issues = append(issues, fmt.Sprintf("clan %d: member %d does not point back to the clan", c.ID, m.UserID))
+			}
+		}
+		if leaders != 1 {
+			issues = append(issues, fmt.Sprintf("clan %d: has %d leaders", c.ID, leaders))
+		}
+		if c.Level < 0 || c.Level > clanMaxLevel || badAmount(c.Treasury) {
+			issues = append(issues, fmt.Sprintf("clan %d: invalid level %d or treasury %v", c.ID, c.Level, c.Treasury))
+		}
+	}
+	for _, u := range sortedUsers(s) {
+		if c, ok := s.Clans[u.ClanID]; u.ClanID != 0 && (!ok || clanMember(c, u.ID) == nil) {
+			issues = append(issues, fmt.Sprintf("user %d: not a member of clan %d", u.ID, u.ClanID))
+		}
+	}
+	orderIDs := map[int64]bool{}
+	for _, o := range s.Orders {
+		if _, ok := s.Users[o.UserID]; !ok {
+			issues = append(issues, fmt.Sprintf("order %d: unknown user %d", o.ID, o.UserID))
+		}
+		if orderIDs[o.ID] || o.ID <= 0 || o.ID > s.NextOrderID {
+			issues = append(issues, fmt.Sprintf("order %d: invalid or duplicate id", o.ID))
+		}
+		orderIDs[o.ID] = true
+		if (o.Side != sideBid && o.Side != sideAsk) || !(o.Price > 0) || !(o.Amount > 0) || o.Filled < 0 || o.Filled >= o.Amount {
+			issues = append(issues, fmt.Sprintf("order %d: invalid %s %v BTC at %v (filled %v)", o.ID, o.Side, o.Amount, o.Price, o.Filled))
+		}
+	}
+	for _, t := range s.Transfers {
+		if _, ok := s.Users[t.From]; !ok {
+			issues = append(issues, fmt.Sprintf("transfer %d: unknown sender %d", t.ID, t.From))
+		}
+		if _, ok := s.Users[t.To]; !ok {
+			issues = append(issues, fmt.Sprintf("transfer %d: unknown recipient %d", t.ID, t.To))
+		}
+		if !(t.Amount > 0) || t.Fee < 0 || (t.Currency != currencyBTC && t.Currency != currencyUSD) {
+			issues = append(issues, fmt.Sprintf("transfer %d: invalid amount %v %s (fee %v)", t.ID, t.Amount, t.Currency, t.Fee))
+		}
+	}
+	for key, u := range s.Users {
+		if u.ID != key {
+			issues = append(issues, fmt.Sprintf("user key %d holds record with id %d", key, u.ID))
+		}
+	}
+	return issues
+}
+
+func storeValidate() int {
+	s, err := loadStoreForCLI()
+	if err != nil {
+		return cliFail(err)
+	}
+	issues := validateStore(s)
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if len(issues) > 0 {
+		fmt.Printf("%d issues in %d users\n", len(issues), len(s.Users))
+		return 1
+	}
+	fmt.Printf("OK: %d users, schema version %d\n", len(s.Users), s.SchemaVersion)
+	return 0
+}
+
+func storeExport(args []string) int {
+	fs := flag.NewFlagSet("store export", flag.ContinueOnError)
+	format := fs.String("format", "json", "json or csv")
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	s, err := loadStoreForCLI()
+	if err != nil {
+		return cliFail(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return cliFail(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s); err != nil {
+			return cliFail(err)
+		}
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "username", "balance_btc", "balance_usd", "gpus", "businesses", "mining_rate", "business_income_btc", "business_income_usd", "created_at"})
+		for _, u := range sortedUsers(s) {
+			cw.Write([]string{
+				strconv.FormatInt(u.ID, 10),
+				u.Username,
+				strconv.FormatFloat(u.BalanceBTC, 'f', ratesDecimals, 64),
+				strconv.FormatFloat(u.BalanceUSD, 'f', 2, 64),
+				strconv.Itoa(len(u.Inventory)),
+				strconv.Itoa(len(u.Businesses)),
+				strconv.FormatFloat(totalMiningRate(u), 'f', ratesDecimals, 64),
+				strconv.FormatFloat(totalBusinessIncome(u, currencyBTC), 'f', ratesDecimals, 64),
+				strconv.FormatFloat(totalBusinessIncome(u, currencyUSD), 'f', 2, 64),
+				u.CreatedAt.Format(time.RFC3339),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return cliFail(err)
+		}
+	default:
+		return cliFail(fmt.Errorf("unknown format %q", *format))
+	}
+	return 0
+}
+
+func runCatalogCommand(args []string) int {
+	if len(args) != 1 || args[0] != "lint" {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	initCatalogs()
+	errs, warnings := lintCatalogs()
+	for _, e := range errs {
+		fmt.Println("error:", e)
+	}
+	for _, w := range warnings {
+		fmt.Println("warning:", w)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("OK: %d GPUs, %d businesses, %d coins, %d warnings\n", len(gpuCatalog), len(bizCatalog), len(coinCatalog), len(warnings))
+	return 0
+}
+
+func lintCatalogs() (errs, warnings []string) {
+	gpuIDs := map[int]bool{}
+	gpuNames := map[string]bool{}
+	for _, g := range gpuCatalog {
+		if g.ID <= 0 || gpuIDs[g.ID] {
+			errs = append(errs, fmt.Sprintf("GPU %q has invalid or duplicate id %d", g.Name, g.ID))
+		}
+		gpuIDs[g.ID] = true
+		if g.Name == "" || gpuNames[g.Name] {
+			errs = append(errs, fmt.Sprintf("GPU %d has empty or duplicate name %q", g.ID, g.Name))
+		}
+		gpuNames[g.Name] = true
+		if g.Rate <= 0 || g.Price <= 0 || g.Power <= 0 {
+			errs = append(errs, fmt.Sprintf("GPU %d (%s) has non-positive rate, price or power", g.ID, g.Name))
+		}
+	}
+	for _, a := range gpuCatalog {
+		for _, b := range gpuCatalog {
+			if a.ID != b.ID && a.Price >= b.Price && a.Rate <= b.Rate && (a.Price > b.Price || a.Rate < b.Rate) {
+				warnings = append(warnings, fmt.Sprintf("GPU %d (%s) is dominated by %d (%s)", a.ID, a.Name, b.ID, b.Name))
+				break
+			}
+		}
+	}
+
+	bizIDs := map[int]bool{}
+	bizNames := map[string]bool{}
+	for _, b := range bizCatalog {
+		if b.ID <= 0 || bizIDs[b.ID] {
+			errs = append(errs, fmt.Sprintf("business %q has invalid or duplicate id %d", b.Name, b.ID))
+		}
+		bizIDs[b.ID] = true
+		if b.Name == "" || bizNames[b.Name] {
+			errs = append(errs, fmt.Sprintf("business %d has empty or duplicate name %q", b.ID, b.Name))
+		}
+		bizNames[b.Name] = true
+		if b.Income <= 0 || b.Price <= 0 {
+			errs = append(errs, fmt.Sprintf("business %d (%s) has non-positive income or price", b.ID, b.Name))
+		}
+		if b.Currency != currencyBTC && b.Currency != currencyUSD {
+			errs = append(errs, fmt.Sprintf("business %d (%s) has unknown currency %q", b.ID, b.Name, b.Currency))
+		}
+		if _, ok := incomeTypeLabels[b.Type]; !ok {
+			errs = append(errs, fmt.Sprintf("business %d (%s) has unknown income type %q", b.ID, b.Name, b.Type))
+		}
+	}
+
+	coinSymbols := map[string]bool{}
+	for _, c := range coinCatalog {
+		if c.Symbol == "" || coinSymbols[c.Symbol] {
+			errs = append(errs, fmt.Sprintf("coin %q has empty or duplicate symbol", c.Name))
+		}
+		coinSymbols[c.Symbol] = true
+		if c.BasePrice <= 0 || c.Difficulty <= 0 || c.Volatility < 0 {
+			errs = append(errs, fmt.Sprintf("coin %s has non-positive price or difficulty, or negative volatility", c.Symbol))
+		}
+	}
+	if !coinSymbols[coinBTC] {
+		errs = append(errs, "coin catalog has no BTC")
+	}
+	return errs, warnings
+}
+
+func runEconomyCommand(args []string) int {
+	if len(args) == 0 || args[0] != "simulate" {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("economy simulate", flag.ContinueOnError)
+	days := fs.Int("days", 30, "simulated days")
+	startUSD := fs.Float64("start-usd", cfg.StartBalanceUSD, "starting USD balance")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	initCatalogs()
+	simulateEconomy(os.Stdout, *days, *startUSD)
+	return 0
+}
+
+// simulateEconomy plays an always-online player who sells all BTC every
+// accrual period, claims the daily bonus and greedily buys whatever affordable
+// item has the shortest payback time.
+func simulateEconomy(w io.Writer, days int, startUSD float64) {
+	u := &User{BalanceUSD: startUSD, FarmCapacity: cfg.FarmCapacity}
+	period := 10 * time.Minute
+	steps := days * int(24*time.Hour/period)
+	lastBonus := -cfg.BonusCooldown.Duration
+	var streak int
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DAY\tUSD\tGPUS\tBUSINESSES\tINCOME $/10MIN\tINCOME $/DAY")
+	for step := 1; step <= steps; step++ {
+		now := time.Duration(step) * period
+		u.BalanceUSD += totalIncomeUSD(u) - electricityCost(totalPowerDraw(u), period)
+		if now-lastBonus >= cfg.BonusCooldown.Duration {
+			streak++
+			// Boost days are skipped: the simulation has no wall clock for
+			// them to expire against.
+			switch r := bonusReward(calendarDay(streak)); r.Kind {
+			case rewardUSD:
+				u.BalanceUSD += r.Amount
+			case rewardBTC:
+				u.BalanceUSD += valueUSD(r.Amount, currencyBTC)
+			case rewardGPU:
+				if len(u.Inventory) < u.FarmCapacity {
+					u.Inventory = append(u.Inventory, OwnedGPU{GPUID: r.GPUID, Durability: maxDurability})
+				} else {
+					u.BalanceUSD += gpuByID[r.GPUID].Price
+				}
+			}
+			lastBonus = now
+		}
+
+		for {
+			price, buy := bestPurchase(u)
+			if buy == nil {
+				break
+			}
+			u.BalanceUSD -= price
+			buy()
+		}
+
+		if step%int(24*time.Hour/period) == 0 {
+			income := totalIncomeUSD(u)
+			fmt.Fprintf(tw, "%d\t%.0f\t%d\t%d\t%.2f\t%.0f\n",
+				now/(24*time.Hour), u.BalanceUSD, len(u.Inventory), len(u.Businesses), income, income*144)
+		}
+	}
+	tw.Flush()
+}
+
+func bestPurchase(u *User) (float64, func()) {
+	bestPayback := math.Inf(1)
+	var bestPrice float64
+	var buy func()
+	if len(u.Inventory) < u.FarmCapacity {
+		for _, g := range gpuCatalog {
+			if g.Price > u.BalanceUSD || gpuMinPrestige(g.ID) > u.Prestige {
+				continue
+			}
+			if payback := g.Price / valueUSD(g.Rate, currencyBTC); payback < bestPayback {
+				bestPayback, bestPrice = payback, g.Price
+				buy = func() { u.Inventory = append(u.Inventory, OwnedGPU{GPUID: g.ID, Durability: maxDurability}) }
+			}
+		}
+	}
+	for _, b := range bizCatalog {
+		if b.Price > u.BalanceUSD || findBusiness(u, b.ID) != nil {
+			continue
+		}
+		if payback := b.Price / valueUSD(b.Income, b.Currency); payback < bestPayback {
+			bestPayback, bestPrice = payback, b.Price
+			buy = func() { u.Businesses = append(u.Businesses, OwnedBusiness{ID: b.ID, Level: 1}) }
+		}
+	}
+	for i := range u.Businesses {
+		ob := &u.Businesses[i]
+		biz := bizByID[ob.ID]
+		if cost := managerCost(biz); !ob.Manager && cost <= u.BalanceUSD {
+			gain := valueUSD(businessIncome(*ob), biz.Currency) * (managerIncomeBonus - 1)
+			if payback := cost / gain; payback < bestPayback {
+				bestPayback, bestPrice = payback, cost
+				buy = func() { ob.Manager = true }
+			}
+		}
+		cost := businessUpgradeCost(*ob)
+		if ob.Level >= bizMaxLevel || cost > u.BalanceUSD {
+			continue
+		}
+		next := *ob
+		next.Level++
+		gain := valueUSD(businessIncome(next)-businessIncome(*ob), biz.Currency)
+		if payback := cost / gain; payback < bestPayback {
+			bestPayback, bestPrice = payback, cost
+			buy = func() { ob.Level++ }
+		}
+	}
+	return bestPrice, buy
+}
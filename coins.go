@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	coinBTC  = "BTC"
+	coinETH  = "ETH"
+	coinMOON = "MOON"
+
+	priceReversion = 0.02
+)
+
+// Coin is a mineable currency. GPU rates in the catalog are expressed in BTC;
+// a card mining another coin earns the same USD value at the coin's base
+// price, divided by the coin's difficulty.
+type Coin struct {
+	Symbol     string
+	Name       string
+	BasePrice  float64
+	Volatility float64
+	Difficulty float64
+	Decimals   int
+}
+
+type CoinMarket struct {
+	Price     float64   `json:"price"`
+	PrevPrice float64   `json:"prev_price"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Pool takes a fee from everything its members mine. Variance is the standard
+// deviation of the payout per 10-minute period; longer accruals average out.
+// Solo miners get nothing on accrual and instead win whole blocks.
+type Pool struct {
+	ID       string
+	Name     string
+	Fee      float64
+	Variance float64
+	Solo     bool
+}
+
+var (
+	coinCatalog  []Coin
+	coinBySymbol map[string]Coin
+)
+
+var miningPools = []Pool{
+	{"stable", "Стабильный", 0.03, 0, false},
+	{"large", "Крупный", 0.02, 0.15, false},
+	{"small", "Малый", 0.01, 0.5, false},
+	{poolSolo, "Соло", 0, 0, true},
+}
+
+func buildCoinCatalog() []Coin {
+	return []Coin{
+		{coinBTC, "Bitcoin", cfg.BTCRate, 0.01, 1, 7},
+		{coinETH, "Ethereum", 4200, 0.015, 0.95, 6},
+		{coinMOON, "MoonDoge", 0.25, 0.06, 0.85, 2},
+	}
+}
+
+func validCoin(symbol string) bool {
+	_, ok := coinBySymbol[symbol]
+	return ok
+}
+
+func miningPool(id string) Pool {
+	for _, p := range miningPools {
+		if p.ID == id {
+			return p
+		}
+	}
+	return miningPools[0]
+}
+
+func validPool(id string) bool {
+	for _, p := range miningPools {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// initCoinMarkets adds a market at the base price for every coin the store
+// does not track yet. The caller must hold storeMu.
+func initCoinMarkets() {
+	if store.Coins == nil {
+		store.Coins = map[string]*CoinMarket{}
+	}
+	for _, c := range coinCatalog {
+		if m, ok := store.Coins[c.Symbol]; !ok || m.Price <= 0 {
+			store.Coins[c.Symbol] = &CoinMarket{Price: c.BasePrice, PrevPrice: c.BasePrice, UpdatedAt: time.Now()}
+		}
+	}
+}
+
+// coinPrice is the current USD price of a coin, falling back to its base price
+// when the store has no market for it (e.g. in CLI tools).
+func coinPrice(symbol string) float64 {
+	if m, ok := store.Coins[symbol]; ok && m.Price > 0 {
+		return m.Price
+	}
+	return coinBySymbol[symbol].BasePrice
+}
+
+// updateCoinPrices moves every price by a mean-reverting random walk in log
+// space. The caller must hold storeMu.
+func updateCoinPrices(now time.Time) {
+	for _, c := range coinCatalog {
+		m := store.Coins[c.Symbol]
+		drift := -priceReversion * math.Log(m.Price/c.BasePrice)
+		m.PrevPrice = m.Price
+		m.Price *= math.Exp(drift + c.Volatility*rng.NormFloat64())
+		m.UpdatedAt = now
+	}
+}
+
+func formatCoin(amount float64, symbol string) string {
+	return fmt.Sprintf("%.*f %s", coinBySymbol[symbol].Decimals, amount, symbol)
+}
+
+func formatPrice(price float64) string {
+	switch {
+	case price >= 100:
+		return fmt.Sprintf("%.0f $", price)
+	case price >= 1:
+		return fmt.Sprintf("%.2f $", price)
+	default:
+		return fmt.Sprintf("%.4f $", price)
+	}
+}
+
+func priceTrend(symbol string) string {
+	m, ok := store.Coins[symbol]
+	if !ok || m.PrevPrice <= 0 {
+		return ""
+	}
+	change := (m.Price/m.PrevPrice - 1) * 100
+	if change >= 0 {
+		return fmt.Sprintf("📈 +%.2f%%", change)
+	}
+	return fmt.Sprintf("📉 %.2f%%", change)
+}
+
+func coinBalance(u *User, symbol string) float64 {
+	if symbol == coinBTC {
+		return u.BalanceBTC
+	}
+	return u.Wallet[symbol]
+}
+
+func addCoins(u *User, symbol string, amount float64) {
+	if symbol == coinBTC {
+		u.BalanceBTC += amount
+		return
+	}
+	if u.Wallet == nil {
+		u.Wallet = map[string]float64{}
+	}
+	u.Wallet[symbol] += amount
+}
+
+// cardCoin is the coin a card mines: its own override or the farm default.
+func cardCoin(u *User, card OwnedGPU) string {
+	if card.Coin != "" {
+		return card.Coin
+	}
+	if u.MiningCoin != "" {
+		return u.MiningCoin
+	}
+	return coinBTC
+}
+
+// coinYield converts a BTC-denominated hashrate into coins of symbol. BTC
+// output is also cut by every halving so far.
+func coinYield(rate float64, symbol string) float64 {
+	c := coinBySymbol[symbol]
+	yield := rate * cfg.BTCRate / c.BasePrice / c.Difficulty
+	if symbol == coinBTC {
+		yield *= blockReward()
+	}
+	return yield
+}
+
+// miningYields is what the farm earns per 10 minutes in every coin, after the
+// network difficulty, the pool fee, the clan bonus, prestige and active boosts.
+func miningYields(u *User) map[string]float64 {
+	return boostedYields(u, boostMultiplier(u, boostHashrate, time.Now()))
+}
+
+func boostedYields(u *User, boost float64) map[string]float64 {
+	share := (1 - miningPool(u.Pool).Fee) / networkDifficulty() * (1 + clanMiningBonus(u)) * boost * prestigeMultiplier(u)
+	yields := map[string]float64{}
+	for _, card := range u.Inventory {
+		if rate := gpuRate(card); rate > 0 {
+			symbol := cardCoin(u, card)
+			yields[symbol] += coinYield(rate, symbol) * share
+		}
+	}
+	return yields
+}
+
+func miningIncomeUSD(u *User) float64 {
+	var usd float64
+	for symbol, amount := range miningYields(u) {
+		usd += amount * coinPrice(symbol)
+	}
+	return usd
+}
+
+// payoutFactor is the pool's luck for one payout, with a mean of 1.
+func payoutFactor(p Pool, periods float64) float64 {
+	if p.Variance == 0 || periods <= 0 {
+		return 1
+	}
+	sigma := p.Variance / math.Sqrt(math.Max(1, periods))
+	return math.Exp(sigma*rng.NormFloat64() - sigma*sigma/2)
+}
+
+// creditMining pays out mining between from and to, with hashrate boosts
+// weighted by how long they were active. Solo miners are paid per found
+// block instead.
+func creditMining(u *User, from, to time.Time) {
+	pool := miningPool(u.Pool)
+	if pool.Solo {
+		return
+	}
+	periods := to.Sub(from).Minutes() / 10
+	factor := payoutFactor(pool, periods)
+	for symbol, amount := range boostedYields(u, boostAverage(u, boostHashrate, from, to)) {
+		addCoins(u, symbol, amount*periods*factor)
+		if symbol == coinBTC {
+			addMinedBTC(u, amount*periods*factor)
+		}
+	}
+}
+
+// addMinedBTC records BTC the farm has mined. The amount is also owed to the
+// sale quest: only mined BTC counts toward it, each coin once.
+func addMinedBTC(u *User, amount float64) {
+	u.MinedBTC += amount
+	u.UnsoldMinedBTC += amount
+}
+
+func formatYields(yields map[string]float64) string {
+	if len(yields) == 0 {
+		return "0 BTC"
+	}
+	parts := make([]string, 0, len(yields))
+	for _, c := range coinCatalog {
+		if amount, ok := yields[c.Symbol]; ok {
+			parts = append(parts, formatCoin(amount, c.Symbol))
+		}
+	}
+	return strings.Join(parts, " + ")
+}
+
+func sendMiningSettings(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	rate := totalMiningRate(u)
+	pool := miningPool(u.Pool)
+
+	text := "⛏ *Настройки майнинга*\n\n"
+	text += "Монета фермы по умолчанию:\n"
+	for _, c := range coinCatalog {
+		mark := ""
+		if cardCoin(u, OwnedGPU{}) == c.Symbol {
+			mark = " ✅"
+		}
+		text += fmt.Sprintf("• %s (%s)%s — %s %s, сложность %.2f, ферма дала бы %s/10мин\n",
+			c.Name, c.Symbol, mark, formatPrice(coinPrice(c.Symbol)), priceTrend(c.Symbol), c.Difficulty,
+			formatCoin(coinYield(rate, c.Symbol)*(1-pool.Fee)/networkDifficulty(), c.Symbol))
+	}
+	var overrides int
+	for _, card := range u.Inventory {
+		if card.Coin != "" {
+			overrides++
+		}
+	}
+	if overrides > 0 {
+		text += fmt.Sprintf("Видеокарт с собственной монетой: %d\n", overrides)
+	}
+
+	text += "\nПул:\n"
+	for _, p := range miningPools {
+		mark := ""
+		if p.ID == pool.ID {
+			mark = " ✅"
+		}
+		if p.Solo {
+			text += fmt.Sprintf("• %s%s — без комиссии, награда за весь блок с шансом по доле хешрейта сети\n", p.Name, mark)
+			continue
+		}
+		text += fmt.Sprintf("• %s%s — комиссия %.0f%%, разброс выплат ±%.0f%%\n", p.Name, mark, p.Fee*100, p.Variance*100)
+	}
+	if pool.Solo {
+		if chance := soloBlockChance(u); chance > 0 {
+			text += fmt.Sprintf("Шанс найти блок: %.4f%% (в среднем раз в %s)\n", chance*100, formatPayback(time.Duration(float64(cfg.BlockInterval.Duration)/chance)))
+		}
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+
+	coinRow := make([]tgbotapi.InlineKeyboardButton, 0, len(coinCatalog))
+	for _, c := range coinCatalog {
+		coinRow = append(coinRow, tgbotapi.NewInlineKeyboardButtonData(c.Symbol, "set_coin:"+c.Symbol))
+	}
+	poolRow := make([]tgbotapi.InlineKeyboardButton, 0, len(miningPools))
+	for _, p := range miningPools {
+		poolRow = append(poolRow, tgbotapi.NewInlineKeyboardButtonData(p.Name, "set_pool:"+p.ID))
+	}
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		coinRow,
+		poolRow,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ К ферме", "farm"),
+		),
+	)
+	sendMessageWithKeyboard(chatID, text, kb)
+}
+
+func setMiningCoin(u *User, symbol string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if !validCoin(symbol) {
+		sendMessage(chatID, fmt.Sprintf("Неизвестная монета\n\n%s", currentTime))
+		return
+	}
+	u.MiningCoin = symbol
+	sendMiningSettings(u, chatID)
+}
+
+func setMiningPool(u *User, id string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if !validPool(id) {
+		sendMessage(chatID, fmt.Sprintf("Неизвестный пул\n\n%s", currentTime))
+		return
+	}
+	u.Pool = id
+	sendMiningSettings(u, chatID)
+}
+
+// setGPUCoin points a single card at symbol; an empty symbol makes it follow
+// the farm default again.
+func setGPUCoin(u *User, instanceID int64, symbol string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if symbol != "" && !validCoin(symbol) {
+		sendMessage(chatID, fmt.Sprintf("Неизвестная монета\n\n%s", currentTime))
+		return
+	}
+	_, card := findGPU(u, instanceID)
+	if card == nil {
+		sendMessage(chatID, fmt.Sprintf("Видеокарта #%d не найдена\n\n%s", instanceID, currentTime))
+		return
+	}
+	card.Coin = symbol
+	sendGPUCard(u, instanceID, chatID)
+}
+
+// convertAllCoinsToUSD sells every coin balance at the current prices.
+func convertAllCoinsToUSD(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	var usdAmount float64
+	var sold []string
+	soldBTC := coinBalance(u, coinBTC)
+	for _, c := range coinCatalog {
+		amount := coinBalance(u, c.Symbol)
+		if amount <= 0 {
+			continue
+		}
+		usdAmount += amount * coinPrice(c.Symbol)
+		sold = append(sold, formatCoin(amount, c.Symbol))
+		addCoins(u, c.Symbol, -amount)
+	}
+	if len(sold) == 0 {
+		sendMessage(chatID, fmt.Sprintf("У вас нет монет для конвертации\n\n%s", currentTime))
+		return
+	}
+	for symbol, amount := range u.Wallet {
+		if amount == 0 {
+			delete(u.Wallet, symbol)
+		}
+	}
+	u.BalanceUSD += usdAmount
+
+	text := fmt.Sprintf("💸 *Конвертация завершена*\n\nПродано: %s\nПолучено: %.0f $\n\n%s", strings.Join(sold, ", "), usdAmount, currentTime)
+	sendMessage(chatID, text)
+	if soldBTC > 0 {
+		btcTraded.Publish(BTCTraded{UserID: u.ID, Side: sideAsk, Amount: soldBTC, Price: coinPrice(coinBTC), Venue: venueConvert})
+	}
+}
+
+// walletSymbols lists the non-BTC coins a user holds, in catalog order with
+// unknown symbols last.
+func walletSymbols(u *User) []string {
+	symbols := make([]string, 0, len(u.Wallet))
+	for symbol, amount := range u.Wallet {
+		if amount > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	order := map[string]int{}
+	for i, c := range coinCatalog {
+		order[c.Symbol] = i
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		oi, iok := order[symbols[i]]
+		oj, jok := order[symbols[j]]
+		if iok != jok {
+			return iok
+		}
+		if oi != oj {
+			return oi < oj
+		}
+		return symbols[i] < symbols[j]
+	})
+	return symbols
+}
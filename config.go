@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultConfigFile = "config.json"
+
+// Duration is a time.Duration that reads and writes as a Go duration string
+// ("10m", "24h") in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+type Config struct {
+	StartBalanceUSD float64  `json:"start_balance_usd"`
+	StartBalanceBTC float64  `json:"start_balance_btc"`
+	BTCRate         float64  `json:"btc_rate"`
+	MiningWindow    Duration `json:"mining_window"`
+	ShopPageSize    int      `json:"shop_page_size"`
+	DailyBonusBTC   float64  `json:"daily_bonus_btc"`
+	BonusCooldown   Duration `json:"bonus_cooldown"`
+	// BonusGrace is how long after the cooldown a bonus can still be claimed
+	// without losing the streak.
+	BonusGrace   Duration `json:"bonus_grace"`
+	FarmCapacity int      `json:"farm_capacity"`
+	// ElectricityPrice is the cost of GPU power in USD per kWh.
+	ElectricityPrice float64 `json:"electricity_price"`
+	// PriceUpdateInterval is how often coin prices move.
+	PriceUpdateInterval Duration `json:"price_update_interval"`
+	// DifficultyTargetHashrate is the network hashrate in BTC per 10 minutes
+	// above which difficulty rises.
+	DifficultyTargetHashrate float64  `json:"difficulty_target_hashrate"`
+	DifficultyInterval       Duration `json:"difficulty_interval"`
+	// BlockInterval is how often a block is mined; every HalvingInterval
+	// blocks the BTC mining reward halves.
+	BlockInterval   Duration `json:"block_interval"`
+	HalvingInterval int      `json:"halving_interval"`
+	// RandomSeed makes breakdowns, prices and block draws reproducible; 0
+	// seeds from the clock.
+	RandomSeed int64 `json:"random_seed"`
+
+	// TransferFeeRate is charged to the sender on top of every transfer.
+	TransferFeeRate       float64 `json:"transfer_fee_rate"`
+	TransferDailyLimitUSD float64 `json:"transfer_daily_limit_usd"`
+	// MarketFeeRate is withheld from the seller on every market sale.
+	MarketFeeRate float64 `json:"market_fee_rate"`
+	// Exchange fees are taken from what each side of a trade receives; the
+	// house fills crossing orders at the BTC price plus or minus the spread.
+	ExchangeMakerFee    float64 `json:"exchange_maker_fee"`
+	ExchangeTakerFee    float64 `json:"exchange_taker_fee"`
+	ExchangeHouseSpread float64 `json:"exchange_house_spread"`
+	ClanCreateCost      float64 `json:"clan_create_cost"`
+	// A prestige reset gives floor(sqrt(net worth / PrestigeUnitUSD)) points,
+	// each adding PrestigeBonus to the income multiplier.
+	PrestigeUnitUSD float64 `json:"prestige_unit_usd"`
+	PrestigeBonus   float64 `json:"prestige_bonus"`
+
+	FlushInterval    Duration `json:"flush_interval"`
+	FlushMaxDirty    int      `json:"flush_max_dirty"`
+	BackupInterval   Duration `json:"backup_interval"`
+	BackupKeepHourly int      `json:"backup_keep_hourly"`
+	BackupKeepDaily  int      `json:"backup_keep_daily"`
+	BackupKeepWeekly int      `json:"backup_keep_weekly"`
+
+	AdminIDs []int64 `json:"admin_ids"`
+}
+
+var cfg = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		StartBalanceUSD:  100,
+		StartBalanceBTC:  0,
+		BTCRate:          112937,
+		MiningWindow:     Duration{10 * time.Minute},
+		ShopPageSize:     5,
+		DailyBonusBTC:    0.001,
+		BonusCooldown:    Duration{24 * time.Hour},
+		BonusGrace:       Duration{24 * time.Hour},
+		FarmCapacity:     95,
+		ElectricityPrice: 0.12,
+
+		PriceUpdateInterval:      Duration{time.Minute},
+		DifficultyTargetHashrate: 1,
+		DifficultyInterval:       Duration{time.Hour},
+		BlockInterval:            Duration{10 * time.Minute},
+		HalvingInterval:          4320,
+
+		TransferFeeRate:       0.02,
+		TransferDailyLimitUSD: 50000,
+		MarketFeeRate:         0.05,
+		ExchangeMakerFee:      0.001,
+		ExchangeTakerFee:      0.002,
+		ExchangeHouseSpread:   0.01,
+		ClanCreateCost:        10000,
+		PrestigeUnitUSD:       1000000,
+		PrestigeBonus:         0.1,
+
+		FlushInterval:    Duration{5 * time.Second},
+		FlushMaxDirty:    50,
+		BackupInterval:   Duration{time.Hour},
+		BackupKeepHourly: 24,
+		BackupKeepDaily:  7,
+		BackupKeepWeekly: 4,
+	}
+}
+
+// loadConfig reads the config file named by MINER_CONFIG (config.json by
+// default, optional), applies MINER_* environment overrides and validates the
+// result.
+func loadConfig() (Config, error) {
+	c := defaultConfig()
+
+	path := os.Getenv("MINER_CONFIG")
+	if path == "" {
+		path = defaultConfigFile
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&c); err != nil {
+			return c, fmt.Errorf("parse %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && os.Getenv("MINER_CONFIG") == "":
+	default:
+		return c, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	if err := applyEnvOverrides(&c); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+func applyEnvOverrides(c *Config) error {
+	floats := map[string]*float64{
+		"MINER_START_BALANCE_USD":          &c.StartBalanceUSD,
+		"MINER_START_BALANCE_BTC":          &c.StartBalanceBTC,
+		"MINER_BTC_RATE":                   &c.BTCRate,
+		"MINER_DAILY_BONUS_BTC":            &c.DailyBonusBTC,
+		"MINER_ELECTRICITY_PRICE":          &c.ElectricityPrice,
+		"MINER_DIFFICULTY_TARGET_HASHRATE": &c.DifficultyTargetHashrate,
+		"MINER_TRANSFER_FEE_RATE":          &c.TransferFeeRate,
+		"MINER_TRANSFER_DAILY_LIMIT_USD":   &c.TransferDailyLimitUSD,
+		"MINER_MARKET_FEE_RATE":            &c.MarketFeeRate,
+		"MINER_EXCHANGE_MAKER_FEE":         &c.ExchangeMakerFee,
+		"MINER_EXCHANGE_TAKER_FEE":         &c.ExchangeTakerFee,
+		"MINER_EXCHANGE_HOUSE_SPREAD":      &c.ExchangeHouseSpread,
+		"MINER_CLAN_CREATE_COST":           &c.ClanCreateCost,
+		"MINER_PRESTIGE_UNIT_USD":          &c.PrestigeUnitUSD,
+		"MINER_PRESTIGE_BONUS":             &c.PrestigeBonus,
+	}
+	ints := map[string]*int{
+		"MINER_SHOP_PAGE_SIZE":     &c.ShopPageSize,
+		"MINER_FARM_CAPACITY":      &c.FarmCapacity,
+		"MINER_HALVING_INTERVAL":   &c.HalvingInterval,
+		"MINER_FLUSH_MAX_DIRTY":    &c.FlushMaxDirty,
+		"MINER_BACKUP_KEEP_HOURLY": &c.BackupKeepHourly,
+		"MINER_BACKUP_KEEP_DAILY":  &c.BackupKeepDaily,
+		"MINER_BACKUP_KEEP_WEEKLY": &c.BackupKeepWeekly,
+	}
+	durations := map[string]*Duration{
+		"MINER_MINING_WINDOW":         &c.MiningWindow,
+		"MINER_BONUS_COOLDOWN":        &c.BonusCooldown,
+		"MINER_BONUS_GRACE":           &c.BonusGrace,
+		"MINER_FLUSH_INTERVAL":        &c.FlushInterval,
+		"MINER_BACKUP_INTERVAL":       &c.BackupInterval,
+		"MINER_PRICE_UPDATE_INTERVAL": &c.PriceUpdateInterval,
+		"MINER_DIFFICULTY_INTERVAL":   &c.DifficultyInterval,
+		"MINER_BLOCK_INTERVAL":        &c.BlockInterval,
+	}
+
+	for name, dst := range floats {
+		if v, ok := os.LookupEnv(name); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = f
+		}
+	}
+	for name, dst := range ints {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = n
+		}
+	}
+	for name, dst := range durations {
+		if v, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			dst.Duration = d
+		}
+	}
+	if v, ok := os.LookupEnv("MINER_RANDOM_SEED"); ok {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("MINER_RANDOM_SEED: %w", err)
+		}
+		c.RandomSeed = seed
+	}
+	if v, ok := os.LookupEnv("MINER_ADMIN_IDS"); ok {
+		c.AdminIDs = nil
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return fmt.Errorf("MINER_ADMIN_IDS: %w", err)
+			}
+			c.AdminIDs = append(c.AdminIDs, id)
+		}
+	}
+	return nil
+}
+
+func (c Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.StartBalanceUSD >= 0, "start_balance_usd must not be negative")
+	check(c.StartBalanceBTC >= 0, "start_balance_btc must not be negative")
+	check(c.BTCRate > 0, "btc_rate must be positive")
+	check(c.MiningWindow.Duration > 0, "mining_window must be positive")
+	check(c.ShopPageSize > 0 && c.ShopPageSize <= 10, "shop_page_size must be between 1 and 10")
+	check(c.DailyBonusBTC >= 0, "daily_bonus_btc must not be negative")
+	check(c.BonusCooldown.Duration > 0, "bonus_cooldown must be positive")
+	check(c.BonusGrace.Duration >= 0, "bonus_grace must not be negative")
+	check(c.FarmCapacity > 0, "farm_capacity must be positive")
+	check(c.ElectricityPrice >= 0, "electricity_price must not be negative")
+	check(c.PriceUpdateInterval.Duration >= time.Second, "price_update_interval must be at least 1s")
+	check(c.DifficultyTargetHashrate > 0, "difficulty_target_hashrate must be positive")
+	check(c.DifficultyInterval.Duration >= time.Minute, "difficulty_interval must be at least 1m")
+	check(c.BlockInterval.Duration >= time.Second, "block_interval must be at least 1s")
+	check(c.HalvingInterval > 0, "halving_interval must be positive")
+	check(c.TransferFeeRate >= 0 && c.TransferFeeRate < 1, "transfer_fee_rate must be in [0, 1)")
+	check(c.TransferDailyLimitUSD >= 0, "transfer_daily_limit_usd must not be negative")
+	check(c.MarketFeeRate >= 0 && c.MarketFeeRate < 1, "market_fee_rate must be in [0, 1)")
+	check(c.ExchangeMakerFee >= 0 && c.ExchangeMakerFee < 1 && c.ExchangeTakerFee >= 0 && c.ExchangeTakerFee < 1, "exchange fees must be in [0, 1)")
+	check(c.ExchangeHouseSpread >= 0 && c.ExchangeHouseSpread < 1, "exchange_house_spread must be in [0, 1)")
+	check(c.ClanCreateCost >= 0, "clan_create_cost must not be negative")
+	check(c.PrestigeUnitUSD > 0, "prestige_unit_usd must be positive")
+	check(c.PrestigeBonus >= 0, "prestige_bonus must not be negative")
+	check(c.FlushInterval.Duration > 0, "flush_interval must be positive")
+	check(c.FlushMaxDirty > 0, "flush_max_dirty must be positive")
+	check(c.BackupInterval.Duration >= time.Minute, "backup_interval must be at least 1m")
+	check(c.BackupKeepHourly >= 0 && c.BackupKeepDaily >= 0 && c.BackupKeepWeekly >= 0, "backup retention counts must not be negative")
+	check(c.BackupKeepHourly+c.BackupKeepDaily+c.BackupKeepWeekly > 0, "backup retention must keep at least one snapshot")
+	return errors.Join(errs...)
+}
+
+func isAdmin(id int64) bool {
+	for _, admin := range cfg.AdminIDs {
+		if admin == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sendConfig(chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Ошибка конфигурации: %v", err))
+		return
+	}
+	text := "⚙️ *Конфигурация*\n\n```\n" + string(data) + "\n```\n"
+	text += fmt.Sprintf("Сохранение: %s\n", flushMetrics.summary())
+	text += fmt.Sprintf("События: %s\n", eventSummary())
+	text += fmt.Sprintf("\n%s", currentTime)
+	sendMessage(chatID, text)
+}
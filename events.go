@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const eventQueueSize = 256
+
+// Game events. They carry IDs and values rather than *User so that async
+// subscribers, which run outside storeMu, never touch live state.
+type (
+	// GPUPurchased is published when a player gets a card from the shop or,
+	// with a non-zero Seller, from another player on the market.
+	GPUPurchased struct {
+		UserID int64
+		GPUID  int
+		Price  float64
+		Seller int64
+	}
+	BusinessPurchased struct {
+		UserID     int64
+		BusinessID int
+		Price      float64
+	}
+	// BTCTraded is published for every BTC buy or sell: in the shop, when
+	// converting mined coins, and for each exchange fill on both sides. House
+	// marks an exchange fill against the house quote rather than a player.
+	BTCTraded struct {
+		UserID int64
+		Side   string
+		Amount float64
+		Price  float64
+		Venue  string
+		House  bool
+	}
+	BonusClaimed struct {
+		UserID int64
+		Day    int
+		Streak int
+	}
+	// EarningsAccrued is published on every accrual, even an empty one.
+	EarningsAccrued struct {
+		UserID   int64
+		Periods  float64
+		MinedBTC float64
+	}
+)
+
+const (
+	venueShop     = "shop"
+	venueConvert  = "convert"
+	venueExchange = "exchange"
+)
+
+// Topic is a typed in-process event stream. Synchronous subscribers run in
+// the publisher's goroutine, so they hold storeMu exactly when the publisher
+// does. Async subscribers get their own goroutine and a bounded queue; when
+// the queue is full the event is dropped rather than blocking the game.
+type Topic[E any] struct {
+	name  string
+	mu    sync.Mutex
+	sync  []func(E)
+	async []chan E
+}
+
+func (t *Topic[E]) Subscribe(fn func(E)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sync = append(t.sync, fn)
+}
+
+func (t *Topic[E]) SubscribeAsync(fn func(E)) {
+	ch := make(chan E, eventQueueSize)
+	go func() {
+		for e := range ch {
+			fn(e)
+		}
+	}()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.async = append(t.async, ch)
+}
+
+func (t *Topic[E]) Publish(e E) {
+	t.mu.Lock()
+	subs, queues := t.sync, t.async
+	t.mu.Unlock()
+	for _, fn := range subs {
+		fn(e)
+	}
+	for _, ch := range queues {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("Event queue for %s is full, dropping event", t.name)
+		}
+	}
+}
+
+var (
+	gpuPurchased      = &Topic[GPUPurchased]{name: "GPUPurchased"}
+	businessPurchased = &Topic[BusinessPurchased]{name: "BusinessPurchased"}
+	btcTraded         = &Topic[BTCTraded]{name: "BTCTraded"}
+	bonusClaimed      = &Topic[BonusClaimed]{name: "BonusClaimed"}
+	earningsAccrued   = &Topic[EarningsAccrued]{name: "EarningsAccrued"}
+
+	eventCountsMu sync.Mutex
+	eventCounts   = map[string]int{}
+)
+
+// withUser adapts a handler of a live user for synchronous subscribers.
+func withUser(id int64, fn func(u *User)) {
+	if u, ok := store.Users[id]; ok {
+		fn(u)
+	}
+}
+
+// subscribeGameEvents wires achievements, quests and event statistics to the
+// game events. It is called once at startup.
+//
+// Quests only count trades that cost something to repeat: market and
+// exchange deals with another player worth at least questMinTradeUSD, and
+// sales of mined BTC up to what was mined. House fills and BTC bought in the
+// shop and sold again do not count.
+func subscribeGameEvents() {
+	gpuPurchased.Subscribe(func(e GPUPurchased) {
+		marketTrade := e.Seller != 0 && e.Price >= questMinTradeUSD
+		withUser(e.UserID, func(u *User) {
+			checkAchievements(u)
+			advanceQuests(u, questBuyGPU, 1)
+			if marketTrade {
+				advanceQuests(u, questMarketTrade, 1)
+			}
+		})
+		if marketTrade {
+			withUser(e.Seller, func(u *User) {
+				checkAchievements(u)
+				advanceQuests(u, questMarketTrade, 1)
+			})
+		}
+	})
+	businessPurchased.Subscribe(func(e BusinessPurchased) {
+		withUser(e.UserID, checkAchievements)
+	})
+	btcTraded.Subscribe(func(e BTCTraded) {
+		withUser(e.UserID, func(u *User) {
+			checkAchievements(u)
+			if e.Side == sideAsk && e.Venue == venueConvert {
+				counted := min(e.Amount, u.UnsoldMinedBTC)
+				u.UnsoldMinedBTC -= counted
+				advanceQuests(u, questConvertBTC, counted)
+			}
+			if e.Venue == venueExchange && !e.House && e.Amount*e.Price >= questMinTradeUSD {
+				advanceQuests(u, questMarketTrade, 1)
+			}
+		})
+	})
+	bonusClaimed.Subscribe(func(e BonusClaimed) {
+		withUser(e.UserID, func(u *User) {
+			checkAchievements(u)
+			advanceQuests(u, questClaimBonus, 1)
+		})
+	})
+	earningsAccrued.Subscribe(func(e EarningsAccrued) {
+		withUser(e.UserID, checkAchievements)
+	})
+
+	countEvent := func(name string) {
+		eventCountsMu.Lock()
+		eventCounts[name]++
+		eventCountsMu.Unlock()
+	}
+	gpuPurchased.SubscribeAsync(func(GPUPurchased) { countEvent(gpuPurchased.name) })
+	businessPurchased.SubscribeAsync(func(BusinessPurchased) { countEvent(businessPurchased.name) })
+	btcTraded.SubscribeAsync(func(BTCTraded) { countEvent(btcTraded.name) })
+	bonusClaimed.SubscribeAsync(func(BonusClaimed) { countEvent(bonusClaimed.name) })
+	earningsAccrued.SubscribeAsync(func(EarningsAccrued) { countEvent(earningsAccrued.name) })
+}
+
+// eventSummary lists how many events of each type were published since
+// startup.
+func eventSummary() string {
+	eventCountsMu.Lock()
+	defer eventCountsMu.Unlock()
+	if len(eventCounts) == 0 {
+		return "нет"
+	}
+	names := make([]string, 0, len(eventCounts))
+	for name := range eventCounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, eventCounts[name])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPublishRunsSyncSubscribersInOrder(t *testing.T) {
+	topic := &Topic[BonusClaimed]{name: "test"}
+	var got []string
+	topic.Subscribe(func(e BonusClaimed) { got = append(got, "first") })
+	topic.Subscribe(func(e BonusClaimed) { got = append(got, "second") })
+	topic.Subscribe(func(e BonusClaimed) { got = append(got, "third") })
+
+	topic.Publish(BonusClaimed{UserID: 1})
+	if want := []string{"first", "second", "third"}; !slices.Equal(got, want) {
+		t.Fatalf("subscribers ran as %v, want %v", got, want)
+	}
+}
+
+func TestPublishDeliversToAsyncSubscribers(t *testing.T) {
+	topic := &Topic[BTCTraded]{name: "test"}
+	got := make(chan BTCTraded, 1)
+	topic.SubscribeAsync(func(e BTCTraded) { got <- e })
+
+	sent := BTCTraded{UserID: 7, Side: sideAsk, Amount: 0.5, Venue: venueExchange}
+	topic.Publish(sent)
+	select {
+	case e := <-got:
+		if e != sent {
+			t.Fatalf("async subscriber got %+v, want %+v", e, sent)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("async subscriber did not receive the event")
+	}
+}
+
+func TestPublishDropsWhenQueueIsFull(t *testing.T) {
+	topic := &Topic[EarningsAccrued]{name: "test"}
+	release := make(chan struct{})
+	received := make(chan struct{}, 2*eventQueueSize)
+	topic.SubscribeAsync(func(EarningsAccrued) {
+		<-release
+		received <- struct{}{}
+	})
+
+	// One event is held by the blocked subscriber and eventQueueSize wait in
+	// the queue; everything past that must be dropped without blocking.
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < eventQueueSize+10; i++ {
+			topic.Publish(EarningsAccrued{UserID: int64(i)})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a full queue")
+	}
+
+	close(release)
+	count := 0
+	for done := false; !done; {
+		select {
+		case <-received:
+			count++
+		case <-time.After(200 * time.Millisecond):
+			done = true
+		}
+	}
+	if count > eventQueueSize+1 {
+		t.Fatalf("subscriber got %d events, want at most %d", count, eventQueueSize+1)
+	}
+	if count == 0 {
+		t.Fatal("subscriber got no events")
+	}
+}
+
+func TestTopicsDeliverOnlyTheirOwnEvents(t *testing.T) {
+	gpus := &Topic[GPUPurchased]{name: "gpus"}
+	businesses := &Topic[BusinessPurchased]{name: "businesses"}
+	var gotGPUs []GPUPurchased
+	var gotBusinesses []BusinessPurchased
+	gpus.Subscribe(func(e GPUPurchased) { gotGPUs = append(gotGPUs, e) })
+	businesses.Subscribe(func(e BusinessPurchased) { gotBusinesses = append(gotBusinesses, e) })
+
+	gpus.Publish(GPUPurchased{UserID: 1, GPUID: 3})
+	gpus.Publish(GPUPurchased{UserID: 2, GPUID: 4})
+	businesses.Publish(BusinessPurchased{UserID: 3, BusinessID: 5})
+
+	if len(gotGPUs) != 2 || gotGPUs[0].GPUID != 3 || gotGPUs[1].GPUID != 4 {
+		t.Fatalf("GPU topic delivered %+v", gotGPUs)
+	}
+	if len(gotBusinesses) != 1 || gotBusinesses[0].BusinessID != 5 {
+		t.Fatalf("business topic delivered %+v", gotBusinesses)
+	}
+}
+
+func TestWithUserSkipsUnknownUsers(t *testing.T) {
+	saved := store
+	defer func() { store = saved }()
+	store = Store{Users: map[int64]*User{42: {ID: 42}}}
+
+	var seen []int64
+	for _, id := range []int64{42, 43} {
+		withUser(id, func(u *User) { seen = append(seen, u.ID) })
+	}
+	if !slices.Equal(seen, []int64{42}) {
+		t.Fatalf("withUser called for %v, want only [42]", seen)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	sideBid = "bid"
+	sideAsk = "ask"
+
+	houseID              = 0
+	exchangeDepthLevels  = 5
+	exchangeTradeHistory = 100
+	exchangeMinAmount    = 0.00001
+	exchangeDust         = 1e-12
+	depthBarWidth        = 10
+)
+
+// Order is a resting limit order on the BTC/USD book. Funds are reserved when
+// it is placed: a bid holds Amount*Price USD, an ask holds Amount BTC.
+type Order struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Side      string    `json:"side"`
+	Price     float64   `json:"price"`
+	Amount    float64   `json:"amount"`
+	Filled    float64   `json:"filled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (o Order) remaining() float64 {
+	return o.Amount - o.Filled
+}
+
+// Trade is one fill. Buyer or Seller is houseID when the house filled it.
+type Trade struct {
+	ID     int64     `json:"id"`
+	Price  float64   `json:"price"`
+	Amount float64   `json:"amount"`
+	Buyer  int64     `json:"buyer"`
+	Seller int64     `json:"seller"`
+	Taker  string    `json:"taker"`
+	At     time.Time `json:"at"`
+}
+
+// houseQuote is the price at which the house buys and sells BTC on the
+// exchange: the market price with a spread on either side.
+func houseQuote() (bid, ask float64) {
+	p := coinPrice(coinBTC)
+	return p * (1 - cfg.ExchangeHouseSpread), p * (1 + cfg.ExchangeHouseSpread)
+}
+
+// crossingOrders returns the indices of resting orders of other users that an
+// incoming order can trade with, in price-time priority.
+func crossingOrders(taker Order) []int {
+	var idx []int
+	for i, o := range store.Orders {
+		if o.Side == taker.Side || o.UserID == taker.UserID {
+			continue
+		}
+		if taker.Side == sideBid && o.Price <= taker.Price || taker.Side == sideAsk && o.Price >= taker.Price {
+			idx = append(idx, i)
+		}
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		oa, ob := store.Orders[idx[a]], store.Orders[idx[b]]
+		if oa.Price != ob.Price {
+			if taker.Side == sideBid {
+				return oa.Price < ob.Price
+			}
+			return oa.Price > ob.Price
+		}
+		return oa.ID < ob.ID
+	})
+	return idx
+}
+
+// settleBuyer credits bought BTC minus the fee and refunds the part of the
+// USD reservation not needed because the trade price beat the limit.
+func settleBuyer(id int64, limit, price, amount, fee float64) {
+	if u, ok := store.Users[id]; ok {
+		u.BalanceUSD += (limit - price) * amount
+		u.BalanceBTC += amount * (1 - fee)
+		markDirty(u)
+	}
+}
+
+func settleSeller(id int64, price, amount, fee float64) {
+	if u, ok := store.Users[id]; ok {
+		u.BalanceUSD += price * amount * (1 - fee)
+		markDirty(u)
+	}
+}
+
+// fill executes amount of taker against the maker at price. maker is nil for
+// the house.
+func fill(taker *Order, maker *Order, price, amount float64, now time.Time) Trade {
+	t := Trade{Price: price, Amount: amount, Taker: taker.Side, At: now}
+	makerID, makerLimit := int64(houseID), price
+	if maker != nil {
+		makerID, makerLimit = maker.UserID, maker.Price
+		maker.Filled += amount
+	}
+	taker.Filled += amount
+
+	if taker.Side == sideBid {
+		t.Buyer, t.Seller = taker.UserID, makerID
+		settleBuyer(taker.UserID, taker.Price, price, amount, cfg.ExchangeTakerFee)
+		if maker != nil {
+			settleSeller(makerID, price, amount, cfg.ExchangeMakerFee)
+		}
+	} else {
+		t.Buyer, t.Seller = makerID, taker.UserID
+		settleSeller(taker.UserID, price, amount, cfg.ExchangeTakerFee)
+		if maker != nil {
+			settleBuyer(makerID, makerLimit, price, amount, cfg.ExchangeMakerFee)
+		}
+	}
+
+	store.NextTradeID++
+	t.ID = store.NextTradeID
+	store.Trades = append(store.Trades, t)
+	if len(store.Trades) > exchangeTradeHistory {
+		store.Trades = store.Trades[len(store.Trades)-exchangeTradeHistory:]
+	}
+	return t
+}
+
+// placeOrder reserves the funds, matches the order against the book in
+// price-time priority with the house quote standing in as soon as it is the
+// better price, and rests whatever is left on the book.
+func placeOrder(u *User, side string, amount, price float64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if !(amount >= exchangeMinAmount) || !(price > 0) {
+		sendMessage(chatID, fmt.Sprintf("Минимальная заявка — %.5f BTC, цена должна быть положительной\n\n%s", exchangeMinAmount, currentTime))
+		return
+	}
+	if side == sideBid {
+		if u.BalanceUSD < amount*price {
+			sendMessage(chatID, fmt.Sprintf("Недостаточно USD: заявка резервирует %.2f $\n\n%s", amount*price, currentTime))
+			return
+		}
+		u.BalanceUSD -= amount * price
+	} else {
+		if u.BalanceBTC < amount {
+			sendMessage(chatID, fmt.Sprintf("Недостаточно BTC для продажи\n\n%s", currentTime))
+			return
+		}
+		u.BalanceBTC -= amount
+	}
+
+	now := time.Now()
+	store.NextOrderID++
+	order := Order{ID: store.NextOrderID, UserID: u.ID, Side: side, Price: price, Amount: amount, CreatedAt: now}
+
+	var trades []Trade
+	filledMakers := map[int64][]Trade{}
+	houseBid, houseAsk := houseQuote()
+	for _, i := range crossingOrders(order) {
+		maker := &store.Orders[i]
+		if order.remaining() <= exchangeDust ||
+			side == sideBid && price >= houseAsk && houseAsk < maker.Price ||
+			side == sideAsk && price <= houseBid && houseBid > maker.Price {
+			break
+		}
+		t := fill(&order, maker, maker.Price, min(order.remaining(), maker.remaining()), now)
+		trades = append(trades, t)
+		filledMakers[maker.UserID] = append(filledMakers[maker.UserID], t)
+	}
+	if order.remaining() > exchangeDust {
+		if side == sideBid && price >= houseAsk {
+			trades = append(trades, fill(&order, nil, houseAsk, order.remaining(), now))
+		} else if side == sideAsk && price <= houseBid {
+			trades = append(trades, fill(&order, nil, houseBid, order.remaining(), now))
+		}
+	}
+
+	resting := store.Orders[:0]
+	for _, o := range store.Orders {
+		if o.remaining() > exchangeDust {
+			resting = append(resting, o)
+		}
+	}
+	store.Orders = resting
+	if order.remaining() > exchangeDust {
+		store.Orders = append(store.Orders, order)
+	}
+	if len(trades) > 0 {
+		log.Printf("Order %d by %d: %s %.8f BTC at %.2f, %d fills", order.ID, u.ID, side, amount, price, len(trades))
+	}
+
+	text := fmt.Sprintf("📈 *Заявка #%d*\n\n", order.ID)
+	if len(trades) == 0 {
+		text += "Встречных заявок нет, заявка ожидает в стакане\n"
+	}
+	for _, t := range trades {
+		text += fmt.Sprintf("Исполнено %.5f BTC по %.0f $\n", t.Amount, t.Price)
+	}
+	if order.Filled > exchangeDust && order.remaining() > exchangeDust {
+		text += fmt.Sprintf("Остаток %.5f BTC ожидает в стакане\n", order.remaining())
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+	sendMessage(chatID, text)
+
+	for id, fills := range filledMakers {
+		var amount float64
+		for _, t := range fills {
+			amount += t.Amount
+		}
+		queueNotice(id, fmt.Sprintf("📈 Ваши заявки на бирже исполнены: %.5f BTC\n\n%s", amount, currentTime))
+		for _, t := range fills {
+			makerSide := sideBid
+			if t.Seller == id {
+				makerSide = sideAsk
+			}
+			btcTraded.Publish(BTCTraded{UserID: id, Side: makerSide, Amount: t.Amount, Price: t.Price, Venue: venueExchange})
+		}
+	}
+	for _, t := range trades {
+		house := t.Buyer == houseID || t.Seller == houseID
+		btcTraded.Publish(BTCTraded{UserID: u.ID, Side: side, Amount: t.Amount, Price: t.Price, Venue: venueExchange, House: house})
+	}
+}
+
+// placeOrderCommand handles "/bid <количество> <цена>" and "/ask ...".
+func placeOrderCommand(u *User, side string, parts []string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if len(parts) == 3 {
+		amount, err1 := strconv.ParseFloat(parts[1], 64)
+		price, err2 := strconv.ParseFloat(parts[2], 64)
+		if err1 == nil && err2 == nil {
+			placeOrder(u, side, amount, price, chatID)
+			return
+		}
+	}
+	sendMessage(chatID, fmt.Sprintf("Используйте: %s [количество BTC] [цена в $ за 1 BTC]\n\n%s", parts[0], currentTime))
+}
+
+func cancelOrder(u *User, id int64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	for i, o := range store.Orders {
+		if o.ID != id || o.UserID != u.ID {
+			continue
+		}
+		if o.Side == sideBid {
+			u.BalanceUSD += o.remaining() * o.Price
+		} else {
+			u.BalanceBTC += o.remaining()
+		}
+		store.Orders = append(store.Orders[:i], store.Orders[i+1:]...)
+		sendMessage(chatID, fmt.Sprintf("Заявка #%d отменена, резерв возвращён\n\n%s", id, currentTime))
+		sendExchange(u, chatID)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Заявка #%d не найдена\n\n%s", id, currentTime))
+}
+
+type depthLevel struct {
+	Price  float64
+	Amount float64
+}
+
+// bookDepth aggregates one side of the book by price, best price first.
+func bookDepth(side string) []depthLevel {
+	byPrice := map[float64]float64{}
+	for _, o := range store.Orders {
+		if o.Side == side {
+			byPrice[o.Price] += o.remaining()
+		}
+	}
+	levels := make([]depthLevel, 0, len(byPrice))
+	for p, a := range byPrice {
+		levels = append(levels, depthLevel{p, a})
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if side == sideBid {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+	return levels[:min(len(levels), exchangeDepthLevels)]
+}
+
+func depthBar(amount, largest float64) string {
+	return strings.Repeat("█", max(1, int(amount/largest*depthBarWidth+0.5)))
+}
+
+func sendExchange(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	asks, bids := bookDepth(sideAsk), bookDepth(sideBid)
+	var largest float64
+	for _, l := range append(append([]depthLevel{}, asks...), bids...) {
+		largest = max(largest, l.Amount)
+	}
+
+	text := "📈 *Биржа BTC/USD*\n\n```\n"
+	for i := len(asks) - 1; i >= 0; i-- {
+		text += fmt.Sprintf("%9.0f $ | %.5f %s\n", asks[i].Price, asks[i].Amount, depthBar(asks[i].Amount, largest))
+	}
+	if len(asks) == 0 {
+		text += "      нет продавцов\n"
+	}
+	text += "----------+-----------\n"
+	for _, l := range bids {
+		text += fmt.Sprintf("%9.0f $ | %.5f %s\n", l.Price, l.Amount, depthBar(l.Amount, largest))
+	}
+	if len(bids) == 0 {
+		text += "      нет покупателей\n"
+	}
+	text += "```\n"
+	houseBid, houseAsk := houseQuote()
+	text += fmt.Sprintf("Котировка биржи: покупка %.0f $, продажа %.0f $\n", houseBid, houseAsk)
+	text += fmt.Sprintf("Комиссия: мейкер %.2f%%, тейкер %.2f%%\n", cfg.ExchangeMakerFee*100, cfg.ExchangeTakerFee*100)
+
+	if len(store.Trades) > 0 {
+		text += "\nПоследние сделки:\n"
+		for i := len(store.Trades) - 1; i >= max(0, len(store.Trades)-exchangeDepthLevels); i-- {
+			t := store.Trades[i]
+			kind := "покупка"
+			if t.Taker == sideAsk {
+				kind = "продажа"
+			}
+			text += fmt.Sprintf("%s %s %.5f BTC по %.0f $\n", t.At.Format("15:04"), kind, t.Amount, t.Price)
+		}
+	}
+
+	kbRows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	var mine int
+	for _, o := range store.Orders {
+		if o.UserID != u.ID {
+			continue
+		}
+		if mine == 0 {
+			text += "\nВаши заявки:\n"
+		}
+		mine++
+		kind := "покупка"
+		if o.Side == sideAsk {
+			kind = "продажа"
+		}
+		text += fmt.Sprintf("#%d %s %.5f из %.5f BTC по %.0f $\n", o.ID, kind, o.remaining(), o.Amount, o.Price)
+		kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("❌ Отменить #%d", o.ID), fmt.Sprintf("cancel_order:%d", o.ID)),
+		))
+	}
+	text += "\nЗаявки: /bid [BTC] [цена] — купить, /ask [BTC] [цена] — продать\n"
+	text += fmt.Sprintf("\n%s", currentTime)
+
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔄 Обновить", "exchange"),
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+	))
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxDurability       = 100.0
+	wearPerHour         = 0.5
+	breakChancePerHour  = 0.002
+	wornRateFloor       = 0.7
+	repairBaseCostRate  = 0.05
+	repairWearCostRate  = 0.25
+	repairBrokenSurplus = 0.10
+	resaleRate          = 0.4
+	gpuModelPageSize    = 10
+)
+
+// rng drives every random game event. It is only used under storeMu and is
+// reseeded from cfg.RandomSeed when one is configured.
+var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+const (
+	profileStock     = "stock"
+	profileOverclock = "overclock"
+	profileUndervolt = "undervolt"
+)
+
+// TuningProfile trades hashrate against power draw and wear. The multipliers
+// apply to the catalog rate, the catalog power and the wear/breakdown rates.
+type TuningProfile struct {
+	ID        string
+	Name      string
+	RateMult  float64
+	PowerMult float64
+	WearMult  float64
+}
+
+var tuningProfiles = []TuningProfile{
+	{profileStock, "Сток", 1.0, 1.0, 1.0},
+	{profileOverclock, "Разгон", 1.25, 1.4, 1.8},
+	{profileUndervolt, "Андервольт", 0.85, 0.7, 0.6},
+}
+
+func tuningProfile(id string) TuningProfile {
+	for _, p := range tuningProfiles {
+		if p.ID == id {
+			return p
+		}
+	}
+	return tuningProfiles[0]
+}
+
+func validProfile(id string) bool {
+	for _, p := range tuningProfiles {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+type OwnedGPU struct {
+	InstanceID    int64     `json:"instance_id"`
+	GPUID         int       `json:"gpu_id"`
+	PurchasePrice float64   `json:"purchase_price"`
+	PurchasedAt   time.Time `json:"purchased_at"`
+	Durability    float64   `json:"durability"`
+	Broken        bool      `json:"broken"`
+	Profile       string    `json:"profile,omitempty"`
+	Coin          string    `json:"coin,omitempty"`
+}
+
+// newGPU creates a mint-condition card with a store-wide unique instance ID.
+// The caller must hold storeMu.
+func newGPU(g GPU, price float64) OwnedGPU {
+	store.NextGPUID++
+	return OwnedGPU{
+		InstanceID:    store.NextGPUID,
+		GPUID:         g.ID,
+		PurchasePrice: price,
+		PurchasedAt:   time.Now(),
+		Durability:    maxDurability,
+	}
+}
+
+func findGPU(u *User, instanceID int64) (int, *OwnedGPU) {
+	for i := range u.Inventory {
+		if u.Inventory[i].InstanceID == instanceID {
+			return i, &u.Inventory[i]
+		}
+	}
+	return -1, nil
+}
+
+func removeGPU(u *User, index int) OwnedGPU {
+	card := u.Inventory[index]
+	u.Inventory = append(u.Inventory[:index], u.Inventory[index+1:]...)
+	return card
+}
+
+type gpuGroup struct {
+	GPU     GPU
+	Cards   []OwnedGPU
+	Broken  int
+	Rate    float64
+	AvgWear float64
+}
+
+// groupGPUs summarises the inventory per catalog model, ordered by catalog
+// ID.
+func groupGPUs(u *User) []gpuGroup {
+	byModel := map[int]*gpuGroup{}
+	for _, card := range u.Inventory {
+		g, ok := gpuByID[card.GPUID]
+		if !ok {
+			continue
+		}
+		grp, ok := byModel[g.ID]
+		if !ok {
+			grp = &gpuGroup{GPU: g}
+			byModel[g.ID] = grp
+		}
+		grp.Cards = append(grp.Cards, card)
+		grp.Rate += gpuRate(card)
+		grp.AvgWear += card.Durability
+		if card.Broken {
+			grp.Broken++
+		}
+	}
+	groups := make([]gpuGroup, 0, len(byModel))
+	for _, grp := range byModel {
+		grp.AvgWear /= float64(len(grp.Cards))
+		groups = append(groups, *grp)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].GPU.ID < groups[j].GPU.ID })
+	return groups
+}
+
+// gpuRate is the effective output of a card: nothing while broken, scaled by
+// its tuning profile and down to wornRateFloor of that as durability drops.
+func gpuRate(card OwnedGPU) float64 {
+	g, ok := gpuByID[card.GPUID]
+	if !ok || card.Broken {
+		return 0
+	}
+	rate := g.Rate * tuningProfile(card.Profile).RateMult
+	return rate * (wornRateFloor + (1-wornRateFloor)*card.Durability/maxDurability)
+}
+
+// gpuPower is the draw of a card in watts; broken cards draw nothing.
+func gpuPower(card OwnedGPU) float64 {
+	g, ok := gpuByID[card.GPUID]
+	if !ok || card.Broken {
+		return 0
+	}
+	return g.Power * tuningProfile(card.Profile).PowerMult
+}
+
+func totalPowerDraw(u *User) float64 {
+	var watts float64
+	for _, card := range u.Inventory {
+		watts += gpuPower(card)
+	}
+	return watts
+}
+
+func electricityCost(watts float64, d time.Duration) float64 {
+	return watts / 1000 * d.Hours() * cfg.ElectricityPrice
+}
+
+// poweredFor is how much of span u's USD balance pays electricity for, and
+// the bill for that time. A farm that cannot pay stops once the money runs
+// out instead of mining on credit.
+func poweredFor(u *User, span time.Duration) (time.Duration, float64) {
+	cost := electricityCost(totalPowerDraw(u), span)
+	if cost <= u.BalanceUSD {
+		return span, cost
+	}
+	paid := math.Max(0, u.BalanceUSD)
+	return time.Duration(float64(span) * paid / cost), paid
+}
+
+// farmPowered reports whether u's farm has electricity: it stays off from the
+// accrual that emptied the balance until the player has USD again.
+func farmPowered(u *User) bool {
+	return u.BalanceUSD > 0 || totalPowerDraw(u) == 0
+}
+
+// wearGPU ages card by the given mining time and rolls for a breakdown. Worn
+// cards break more often: at zero durability the hourly chance is five times
+// the base one. It reports whether the card broke.
+func wearGPU(card *OwnedGPU, mined time.Duration) bool {
+	if card.Broken || mined <= 0 {
+		return false
+	}
+	hours := mined.Hours()
+	wear := tuningProfile(card.Profile).WearMult
+	chance := breakChancePerHour * wear * (1 + 4*(1-card.Durability/maxDurability))
+	card.Durability = math.Max(0, card.Durability-wearPerHour*wear*hours)
+	if rng.Float64() < 1-math.Pow(1-chance, hours) {
+		card.Broken = true
+		return true
+	}
+	return false
+}
+
+func repairCost(card OwnedGPU) float64 {
+	g := gpuByID[card.GPUID]
+	cost := g.Price * (repairBaseCostRate + repairWearCostRate*(1-card.Durability/maxDurability))
+	if card.Broken {
+		cost += g.Price * repairBrokenSurplus
+	}
+	return cost
+}
+
+func needsRepair(card OwnedGPU) bool {
+	return card.Broken || card.Durability < maxDurability
+}
+
+func repairAllCost(u *User) (cost float64, count int) {
+	for _, card := range u.Inventory {
+		if needsRepair(card) {
+			cost += repairCost(card)
+			count++
+		}
+	}
+	return cost, count
+}
+
+func brokenGPUCount(u *User) int {
+	var n int
+	for _, card := range u.Inventory {
+		if card.Broken {
+			n++
+		}
+	}
+	return n
+}
+
+func repairAllGPUs(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	cost, count := repairAllCost(u)
+	if count == 0 {
+		sendMessage(chatID, fmt.Sprintf("Все видеокарты в идеальном состоянии\n\n%s", currentTime))
+		return
+	}
+	if u.BalanceUSD < cost {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно средств: ремонт стоит %.0f $\n\n%s", cost, currentTime))
+		return
+	}
+
+	u.BalanceUSD -= cost
+	for i := range u.Inventory {
+		u.Inventory[i].Durability = maxDurability
+		u.Inventory[i].Broken = false
+	}
+
+	sendMessage(chatID, fmt.Sprintf("🔧 *Ремонт завершён*\n\nОтремонтировано видеокарт: %d\nПотрачено: %.0f $\n\n%s", count, cost, currentTime))
+	sendFarm(u, chatID)
+}
+
+func resalePrice(card OwnedGPU) float64 {
+	return gpuByID[card.GPUID].Price * resaleRate * card.Durability / maxDurability
+}
+
+func sendGPUModel(u *User, gpuID, page int, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	g, ok := gpuByID[gpuID]
+	if !ok {
+		sendMessage(chatID, fmt.Sprintf("Эта видеокарта не найдена\n\n%s", currentTime))
+		return
+	}
+	var cards []OwnedGPU
+	for _, card := range u.Inventory {
+		if card.GPUID == gpuID {
+			cards = append(cards, card)
+		}
+	}
+	if len(cards) == 0 {
+		sendMessage(chatID, fmt.Sprintf("У вас нет видеокарт %s\n\n%s", g.Name, currentTime))
+		return
+	}
+
+	totalPages := (len(cards) + gpuModelPageSize - 1) / gpuModelPageSize
+	if page < 1 || page > totalPages {
+		page = 1
+	}
+	start := (page - 1) * gpuModelPageSize
+	end := min(start+gpuModelPageSize, len(cards))
+
+	text := fmt.Sprintf("🖥 *%s* ×%d\n\n", g.Name, len(cards))
+	text += "Профили: "
+	for i, p := range tuningProfiles {
+		if i > 0 {
+			text += ", "
+		}
+		text += fmt.Sprintf("%s — хешрейт ×%.2f, питание ×%.1f, износ ×%.1f", p.Name, p.RateMult, p.PowerMult, p.WearMult)
+	}
+	text += "\n\n"
+
+	kbRows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	profileRow := make([]tgbotapi.InlineKeyboardButton, 0, len(tuningProfiles))
+	for _, p := range tuningProfiles {
+		profileRow = append(profileRow, tgbotapi.NewInlineKeyboardButtonData(
+			"Все: "+p.Name, fmt.Sprintf("tune_model:%d:%s", gpuID, p.ID)))
+	}
+	kbRows = append(kbRows, profileRow)
+	for _, card := range cards[start:end] {
+		state := fmt.Sprintf("%.0f%%", card.Durability)
+		if card.Broken {
+			state = "❌ сломана"
+		}
+		coin := cardCoin(u, card)
+		text += fmt.Sprintf("#%d — %s, %s, %s/10мин\n", card.InstanceID, tuningProfile(card.Profile).Name, state, formatCoin(coinYield(gpuRate(card), coin), coin))
+		kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("#%d (%s)", card.InstanceID, state), fmt.Sprintf("gpu:%d", card.InstanceID)),
+		))
+	}
+	text += fmt.Sprintf("\nСтраница %d/%d\n\n%s", page, totalPages, currentTime)
+
+	navRow := make([]tgbotapi.InlineKeyboardButton, 0)
+	if page > 1 {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("⬅️", fmt.Sprintf("gpu_model:%d:%d", gpuID, page-1)))
+	}
+	if end < len(cards) {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("gpu_model:%d:%d", gpuID, page+1)))
+	}
+	if len(navRow) > 0 {
+		kbRows = append(kbRows, navRow)
+	}
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ К ферме", "farm"),
+	))
+
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
+
+func sendGPUCard(u *User, instanceID int64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	_, card := findGPU(u, instanceID)
+	if card == nil {
+		sendMessage(chatID, fmt.Sprintf("Видеокарта #%d не найдена\n\n%s", instanceID, currentTime))
+		return
+	}
+	g := gpuByID[card.GPUID]
+
+	text := fmt.Sprintf("🖥 *%s* #%d\n\n", g.Name, card.InstanceID)
+	if card.Broken {
+		text += "• Состояние: ❌ сломана\n"
+	} else {
+		text += fmt.Sprintf("• Ресурс: %.0f%%\n", card.Durability)
+	}
+	coin := cardCoin(u, *card)
+	text += fmt.Sprintf("• Доход: %s/10мин до комиссии пула (номинал %.7f BTC)\n", formatCoin(coinYield(gpuRate(*card), coin), coin), g.Rate)
+	if card.Coin != "" {
+		text += fmt.Sprintf("• Монета: %s (своя настройка)\n", coin)
+	} else {
+		text += fmt.Sprintf("• Монета: %s (как у фермы)\n", coin)
+	}
+	text += fmt.Sprintf("• Профиль: %s, потребление %.0f Вт\n", tuningProfile(card.Profile).Name, gpuPower(*card))
+	if !card.PurchasedAt.IsZero() {
+		text += fmt.Sprintf("• Куплена: %s\n", card.PurchasedAt.Format("02.01.2006"))
+	}
+	if card.PurchasePrice > 0 {
+		text += fmt.Sprintf("• Цена покупки: %.0f $\n", card.PurchasePrice)
+	}
+	if !card.Broken {
+		text += "• 🏷 — выставить на рынок за указанную цену\n"
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+
+	var actions []tgbotapi.InlineKeyboardButton
+	if needsRepair(*card) {
+		actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("🔧 Починить (%.0f $)", repairCost(*card)), fmt.Sprintf("repair_gpu:%d", card.InstanceID)))
+	}
+	actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(
+		fmt.Sprintf("💸 Продать (%.0f $)", resalePrice(*card)), fmt.Sprintf("sell_gpu:%d", card.InstanceID)))
+
+	profileRow := make([]tgbotapi.InlineKeyboardButton, 0, len(tuningProfiles))
+	current := tuningProfile(card.Profile).ID
+	for _, p := range tuningProfiles {
+		label := p.Name
+		if p.ID == current {
+			label = "✅ " + label
+		}
+		profileRow = append(profileRow, tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("tune_gpu:%d:%s", card.InstanceID, p.ID)))
+	}
+
+	coinRow := make([]tgbotapi.InlineKeyboardButton, 0, len(coinCatalog)+1)
+	for _, c := range coinCatalog {
+		label := c.Symbol
+		if card.Coin == c.Symbol {
+			label = "✅ " + label
+		}
+		coinRow = append(coinRow, tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("set_gpu_coin:%d:%s", card.InstanceID, c.Symbol)))
+	}
+	if card.Coin != "" {
+		coinRow = append(coinRow, tgbotapi.NewInlineKeyboardButtonData("Как ферма", fmt.Sprintf("set_gpu_coin:%d:", card.InstanceID)))
+	}
+
+	kbRows := [][]tgbotapi.InlineKeyboardButton{profileRow, coinRow, actions}
+	if !card.Broken {
+		listRow := make([]tgbotapi.InlineKeyboardButton, 0, len(marketPriceSteps))
+		for _, share := range marketPriceSteps {
+			price := suggestedPrice(*card, share)
+			listRow = append(listRow, tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("🏷 %.0f $", price), fmt.Sprintf("list_gpu:%d:%.0f", card.InstanceID, price)))
+		}
+		kbRows = append(kbRows, listRow)
+	}
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", fmt.Sprintf("gpu_model:%d:1", card.GPUID)),
+	))
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
+
+func repairGPU(u *User, instanceID int64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	_, card := findGPU(u, instanceID)
+	if card == nil {
+		sendMessage(chatID, fmt.Sprintf("Видеокарта #%d не найдена\n\n%s", instanceID, currentTime))
+		return
+	}
+	if !needsRepair(*card) {
+		sendMessage(chatID, fmt.Sprintf("Видеокарта #%d не нуждается в ремонте\n\n%s", instanceID, currentTime))
+		return
+	}
+	cost := repairCost(*card)
+	if u.BalanceUSD < cost {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно средств: ремонт стоит %.0f $\n\n%s", cost, currentTime))
+		return
+	}
+
+	u.BalanceUSD -= cost
+	card.Durability = maxDurability
+	card.Broken = false
+
+	sendMessage(chatID, fmt.Sprintf("🔧 Видеокарта #%d отремонтирована за %.0f $\n\n%s", instanceID, cost, currentTime))
+	sendGPUCard(u, instanceID, chatID)
+}
+
+func sellGPU(u *User, instanceID int64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	i, card := findGPU(u, instanceID)
+	if card == nil {
+		sendMessage(chatID, fmt.Sprintf("Видеокарта #%d не найдена\n\n%s", instanceID, currentTime))
+		return
+	}
+
+	price := resalePrice(*card)
+	sold := removeGPU(u, i)
+	u.BalanceUSD += price
+
+	sendMessage(chatID, fmt.Sprintf("💸 *Видеокарта продана*\n\n%s #%d\nПолучено: %.0f $\n\n%s",
+		gpuByID[sold.GPUID].Name, sold.InstanceID, price, currentTime))
+	sendFarm(u, chatID)
+}
+
+func tuneGPUModel(u *User, gpuID int, profile string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if !validProfile(profile) {
+		sendMessage(chatID, fmt.Sprintf("Неизвестный профиль\n\n%s", currentTime))
+		return
+	}
+	var n int
+	for i := range u.Inventory {
+		if u.Inventory[i].GPUID == gpuID {
+			u.Inventory[i].Profile = profile
+			n++
+		}
+	}
+	if n == 0 {
+		sendMessage(chatID, fmt.Sprintf("У вас нет таких видеокарт\n\n%s", currentTime))
+		return
+	}
+
+	sendMessage(chatID, fmt.Sprintf("⚙️ Профиль «%s» установлен на %d видеокарт %s\n\n%s",
+		tuningProfile(profile).Name, n, gpuByID[gpuID].Name, currentTime))
+	sendGPUModel(u, gpuID, 1, chatID)
+}
+
+func tuneGPU(u *User, instanceID int64, profile string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if !validProfile(profile) {
+		sendMessage(chatID, fmt.Sprintf("Неизвестный профиль\n\n%s", currentTime))
+		return
+	}
+	_, card := findGPU(u, instanceID)
+	if card == nil {
+		sendMessage(chatID, fmt.Sprintf("Видеокарта #%d не найдена\n\n%s", instanceID, currentTime))
+		return
+	}
+	card.Profile = profile
+	sendGPUCard(u, instanceID, chatID)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Consumable is a one-use item. Using it either starts a boost of BoostKind
+// for Duration, or, for a time warp, instantly pays Warp worth of income.
+// Prices follow the buyer's income: PriceShare of what the item is worth to
+// them, but never less than MinPrice. Since that is below the item's value, a
+// player may buy at most DailyLimit of each item per calendar day, which also
+// caps how far boosts can be extended.
+type Consumable struct {
+	ID          string
+	Name        string
+	Description string
+	BoostKind   string
+	Mult        float64
+	Duration    time.Duration
+	Warp        time.Duration
+	MinPrice    float64
+	PriceShare  float64
+	DailyLimit  int
+}
+
+var consumableCatalog = []Consumable{
+	{
+		ID: "hashrate_x2", Name: "⚡ Ускоритель хешрейта", Description: "Добыча фермы ×2 на 1 час",
+		BoostKind: boostHashrate, Mult: 2, Duration: time.Hour, MinPrice: 500, PriceShare: 0.5, DailyLimit: 2,
+	},
+	{
+		ID: "business_x2", Name: "💼 Ускоритель бизнесов", Description: "Доход бизнесов ×2 на 1 час",
+		BoostKind: boostBusiness, Mult: 2, Duration: time.Hour, MinPrice: 500, PriceShare: 0.5, DailyLimit: 2,
+	},
+	{
+		ID: "time_warp", Name: "⏩ Искривление времени", Description: "Мгновенно начисляет доход фермы и бизнесов за 1 час",
+		Warp: time.Hour, MinPrice: 1000, PriceShare: 0.6, DailyLimit: 1,
+	},
+}
+
+var boostNames = map[string]string{
+	boostHashrate: "⚡ Хешрейт",
+	boostBusiness: "💼 Доход бизнесов",
+}
+
+func findConsumable(id string) (Consumable, bool) {
+	for _, c := range consumableCatalog {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return Consumable{}, false
+}
+
+// consumableValue is the extra USD c would earn u at the current rates,
+// without any active boosts.
+func consumableValue(u *User, c Consumable) float64 {
+	var perPeriod float64
+	if c.BoostKind == boostHashrate || c.Warp > 0 {
+		for symbol, amount := range boostedYields(u, 1) {
+			perPeriod += amount * coinPrice(symbol)
+		}
+	}
+	if c.BoostKind == boostBusiness || c.Warp > 0 {
+		for _, ob := range u.Businesses {
+			perPeriod += valueUSD(businessIncome(ob), bizByID[ob.ID].Currency) * prestigeMultiplier(u)
+		}
+	}
+	periods := c.Warp.Minutes() / 10
+	if c.BoostKind != "" {
+		periods = c.Duration.Minutes() / 10 * (c.Mult - 1)
+	}
+	return perPeriod * periods
+}
+
+func consumablePrice(u *User, c Consumable) float64 {
+	return math.Round(math.Max(c.MinPrice, consumableValue(u, c)*c.PriceShare))
+}
+
+// boughtToday is how many of item id u bought since local midnight.
+func boughtToday(u *User, id string, now time.Time) int {
+	if !u.ItemsBoughtOn.Equal(questPeriodStart(now, false)) {
+		return 0
+	}
+	return u.ItemsBought[id]
+}
+
+func buyConsumable(u *User, id string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	c, ok := findConsumable(id)
+	if !ok {
+		sendMessage(chatID, fmt.Sprintf("Этот предмет не найден\n\n%s", currentTime))
+		return
+	}
+	now := time.Now()
+	bought := boughtToday(u, c.ID, now)
+	if bought >= c.DailyLimit {
+		sendMessage(chatID, fmt.Sprintf("Сегодня больше нельзя купить %s: лимит %d в день\n\n%s", c.Name, c.DailyLimit, currentTime))
+		return
+	}
+	price := consumablePrice(u, c)
+	if u.BalanceUSD < price {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно средств для покупки\n\n%s", currentTime))
+		return
+	}
+	u.BalanceUSD -= price
+	if u.Items == nil {
+		u.Items = map[string]int{}
+	}
+	u.Items[c.ID]++
+	if today := questPeriodStart(now, false); !u.ItemsBoughtOn.Equal(today) {
+		u.ItemsBought = map[string]int{}
+		u.ItemsBoughtOn = today
+	}
+	u.ItemsBought[c.ID] = bought + 1
+	log.Printf("User %d bought item %s for %.2f", u.ID, c.ID, price)
+	sendConsumables(u, chatID)
+}
+
+// warpTime pays d of mining and business income at once, as if the farm had
+// run that long, including electricity but without wear. Like a real accrual,
+// the farm only mines for as long as the balance pays for electricity. Boosts
+// do not apply to it, and solo miners get the expected value of their blocks.
+func warpTime(u *User, d time.Duration) string {
+	powered, bill := poweredFor(u, d)
+	u.BalanceUSD -= bill
+	yields := boostedYields(u, 1)
+	for symbol, amount := range yields {
+		yields[symbol] = amount * powered.Minutes() / 10
+		addCoins(u, symbol, yields[symbol])
+	}
+	addMinedBTC(u, yields[coinBTC])
+	for i := range u.Businesses {
+		accrueBusiness(u, &u.Businesses[i], d.Minutes()/10, prestigeMultiplier(u))
+	}
+	return formatYields(yields)
+}
+
+func useConsumable(u *User, id string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	c, ok := findConsumable(id)
+	if !ok || u.Items[id] <= 0 {
+		sendMessage(chatID, fmt.Sprintf("У вас нет этого предмета\n\n%s", currentTime))
+		return
+	}
+	u.Items[id]--
+	if u.Items[id] == 0 {
+		delete(u.Items, id)
+	}
+	now := time.Now()
+	if c.Warp > 0 {
+		mined := warpTime(u, c.Warp)
+		sendMessage(chatID, fmt.Sprintf("⏩ *Время ускорено на %s*\n\nФерма добыла: %s\nДоход бизнесов начислен\n\n%s", formatPayback(c.Warp), mined, currentTime))
+	} else {
+		addBoost(u, Boost{Kind: c.BoostKind, Mult: c.Mult}, c.Duration, now)
+	}
+	log.Printf("User %d used item %s", u.ID, c.ID)
+	sendConsumables(u, chatID)
+}
+
+// activeBoostLines describes u's running boosts with the time left on each.
+func activeBoostLines(u *User, now time.Time) []string {
+	var lines []string
+	for _, b := range u.Boosts {
+		if b.ExpiresAt.After(now) {
+			lines = append(lines, fmt.Sprintf("%s ×%g: ещё %s", boostNames[b.Kind], b.Mult, formatCountdown(b.ExpiresAt.Sub(now))))
+		}
+	}
+	return lines
+}
+
+func sendConsumables(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	text := "🧪 *Расходники*\n\n"
+	var kbRows [][]tgbotapi.InlineKeyboardButton
+	now := time.Now()
+	for _, c := range consumableCatalog {
+		price := consumablePrice(u, c)
+		bought := boughtToday(u, c.ID, now)
+		text += fmt.Sprintf("*%s* — %.0f $\n%s\nВ наличии: %d · куплено сегодня: %d/%d\n\n", c.Name, price, c.Description, u.Items[c.ID], bought, c.DailyLimit)
+		var row []tgbotapi.InlineKeyboardButton
+		if bought < c.DailyLimit {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Купить %s", c.Name), "buy_item:"+c.ID))
+		}
+		if u.Items[c.ID] > 0 {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("Использовать", "use_item:"+c.ID))
+		}
+		if len(row) > 0 {
+			kbRows = append(kbRows, row)
+		}
+	}
+	if lines := activeBoostLines(u, now); len(lines) > 0 {
+		text += "*Активные эффекты:*\n"
+		for _, line := range lines {
+			text += fmt.Sprintf("• %s\n", line)
+		}
+		text += "\n"
+	}
+	text += fmt.Sprintf("Баланс: %.0f $\n\n%s", u.BalanceUSD, currentTime)
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "shop"),
+	))
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
@@ -1,14 +1,14 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -17,14 +17,8 @@ import (
 const (
 	dataDir       = "data"
 	usersFile     = "data/users.json"
+	worldFile     = "data/world.json"
 	ratesDecimals = 8
-	btcRate       = 112937.0
-
-	shopPageSize = 5
-	miningWindow = 10 * time.Minute
-
-	startBalanceBTC = 0.0
-	startBalanceUSD = 100.0
 )
 
 type GPU struct {
@@ -32,32 +26,73 @@ type GPU struct {
 	Name  string  `json:"name"`
 	Rate  float64 `json:"rate"`
 	Price float64 `json:"price"`
+	Power float64 `json:"power"`
 }
 
 type Business struct {
-	ID     int     `json:"id"`
-	Name   string  `json:"name"`
-	Income float64 `json:"income"`
-	Price  float64 `json:"price"`
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	Income   float64 `json:"income"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+	Type     string  `json:"type"`
 }
 
 type User struct {
-	ID                int64     `json:"id"`
-	Username          string    `json:"username"`
-	BalanceBTC        float64   `json:"balance_btc"`
-	BalanceUSD        float64   `json:"balance_usd"`
-	Inventory         []int     `json:"inventory"`
-	Businesses        []int     `json:"businesses"`
-	CreatedAt         time.Time `json:"created_at"`
-	LastAccrualAt     time.Time `json:"last_accrual_at"`
-	MiningWindowEnd   time.Time `json:"mining_window_end"`
-	LastBonusTime     time.Time `json:"last_bonus_time"`
-	FarmCapacity      int       `json:"farm_capacity"`
-	LastShopMessageID int       `json:"last_shop_message_id"`
+	ID                int64           `json:"id"`
+	Username          string          `json:"username"`
+	BalanceBTC        float64         `json:"balance_btc"`
+	BalanceUSD        float64         `json:"balance_usd"`
+	Inventory         []OwnedGPU      `json:"inventory"`
+	Businesses        []OwnedBusiness `json:"businesses"`
+	CreatedAt         time.Time       `json:"created_at"`
+	LastAccrualAt     time.Time       `json:"last_accrual_at"`
+	MiningWindowEnd   time.Time       `json:"mining_window_end"`
+	LastBonusTime     time.Time       `json:"last_bonus_time"`
+	FarmCapacity      int             `json:"farm_capacity"`
+	LastShopMessageID int             `json:"last_shop_message_id"`
+	// Wallet holds mined coins other than BTC, which stays in BalanceBTC.
+	Wallet     map[string]float64 `json:"wallet,omitempty"`
+	MiningCoin string             `json:"mining_coin,omitempty"`
+	Pool       string             `json:"pool,omitempty"`
+	ClanID     int64              `json:"clan_id,omitempty"`
+	// BonusStreak counts consecutive daily bonuses; it resets when a bonus is
+	// missed by more than the grace window.
+	BonusStreak int     `json:"bonus_streak,omitempty"`
+	Boosts      []Boost `json:"boosts,omitempty"`
+	// MinedBTC is the BTC the farm has mined over its lifetime.
+	MinedBTC float64 `json:"mined_btc,omitempty"`
+	// UnsoldMinedBTC is mined BTC not yet counted toward the sale quest.
+	UnsoldMinedBTC float64              `json:"unsold_mined_btc,omitempty"`
+	Achievements   map[string]time.Time `json:"achievements,omitempty"`
+	Quests         []Quest              `json:"quests,omitempty"`
+	// Items counts unused consumables by ID.
+	Items map[string]int `json:"items,omitempty"`
+	// ItemsBought counts consumables bought on the day starting at
+	// ItemsBoughtOn, for the daily purchase limit.
+	ItemsBought   map[string]int `json:"items_bought,omitempty"`
+	ItemsBoughtOn time.Time      `json:"items_bought_on,omitempty"`
+	// Prestige counts resets; PrestigePoints are what they earned in total.
+	Prestige       int `json:"prestige,omitempty"`
+	PrestigePoints int `json:"prestige_points,omitempty"`
 }
 
 type Store struct {
-	Users map[int64]*User `json:"users"`
+	SchemaVersion  int                    `json:"schema_version"`
+	NextGPUID      int64                  `json:"next_gpu_id"`
+	Users          map[int64]*User        `json:"users"`
+	Coins          map[string]*CoinMarket `json:"coins,omitempty"`
+	Network        Network                `json:"network"`
+	Transfers      []Transfer             `json:"transfers,omitempty"`
+	NextTransferID int64                  `json:"next_transfer_id"`
+	Listings       []Listing              `json:"listings,omitempty"`
+	NextListingID  int64                  `json:"next_listing_id"`
+	Orders         []Order                `json:"orders,omitempty"`
+	Trades         []Trade                `json:"trades,omitempty"`
+	NextOrderID    int64                  `json:"next_order_id"`
+	NextTradeID    int64                  `json:"next_trade_id"`
+	Clans          map[int64]*Clan        `json:"clans,omitempty"`
+	NextClanID     int64                  `json:"next_clan_id"`
 }
 
 var (
@@ -71,6 +106,10 @@ var (
 )
 
 func main() {
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+func serve() {
 	token := os.Getenv("TELEGRAM_BOT_TOKEN")
 	if token == "" {
 		log.Fatal("TELEGRAM_BOT_TOKEN is required")
@@ -85,25 +124,31 @@ func main() {
 	if err := os.MkdirAll(dataDir, 0o755); err != nil {
 		log.Fatal(err)
 	}
-	loadOrInitStore()
-
-	gpuCatalog = buildGPUCatalog()
-	bizCatalog = buildBusinessCatalog()
-
-	gpuByID = make(map[int]GPU)
-	for _, g := range gpuCatalog {
-		gpuByID[g.ID] = g
-	}
-
-	bizByID = make(map[int]Business)
-	for _, b := range bizCatalog {
-		bizByID[b.ID] = b
+	if err := loadOrInitStore(); err != nil {
+		log.Fatalf("Cannot load store, refusing to start: %v", err)
 	}
+	initCatalogs()
+	initCoinMarkets()
+	subscribeGameEvents()
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 30
 	updates := bot.GetUpdatesChan(u)
 
+	stop := make(chan struct{})
+	flusherDone := make(chan struct{})
+	go runFlusher(stop, flusherDone)
+	go runBackups(stop)
+	go runGameClock(stop)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Print("Shutting down, waiting for pending updates")
+		bot.StopReceivingUpdates()
+	}()
+
 	for update := range updates {
 		if update.Message != nil {
 			handleMessage(update.Message)
@@ -111,120 +156,111 @@ func main() {
 			handleCallback(update.CallbackQuery)
 		}
 	}
-}
-
-func loadOrInitStore() {
-	storeMu.Lock()
-	defer storeMu.Unlock()
-	if _, err := os.Stat(usersFile); errors.Is(err, os.ErrNotExist) {
-		store = Store{Users: map[int64]*User{}}
-		mustWriteJSON(usersFile, store)
-		return
-	}
-	f, err := os.Open(usersFile)
-	if err != nil {
-		log.Printf("Error opening users file: %v", err)
-		store = Store{Users: map[int64]*User{}}
-		return
-	}
-	defer f.Close()
-	if err := json.NewDecoder(f).Decode(&store); err != nil {
-		log.Printf("Error decoding users file: %v", err)
-		store = Store{Users: map[int64]*User{}}
-	}
-}
 
-func saveStore() {
-	storeMu.Lock()
-	defer storeMu.Unlock()
-	mustWriteJSON(usersFile, store)
-}
-
-func mustWriteJSON(path string, v any) {
-	if err := os.MkdirAll("data", 0755); err != nil {
-		log.Printf("Error creating data directory: %v", err)
-		return
-	}
-	tmp := path + ".tmp"
-	f, err := os.Create(tmp)
-	if err != nil {
-		log.Printf("Error creating temp file: %v", err)
-		return
-	}
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(v); err != nil {
-		log.Printf("Error encoding JSON: %v", err)
-	}
-	f.Close()
-	if err := os.Rename(tmp, path); err != nil {
-		log.Printf("Error renaming temp file: %v", err)
-	}
+	close(stop)
+	<-flusherDone
 }
 
+// ensureUser returns the stored user, creating it on first contact and keeping
+// the username current so that players can be found by it. The caller must
+// hold storeMu.
 func ensureUser(id int64, username string) *User {
-	storeMu.Lock()
-	defer storeMu.Unlock()
 	u, ok := store.Users[id]
 	if !ok {
 		u = &User{
 			ID:                id,
 			Username:          username,
-			BalanceBTC:        startBalanceBTC,
-			BalanceUSD:        startBalanceUSD,
-			Inventory:         []int{},
-			Businesses:        []int{},
+			BalanceBTC:        cfg.StartBalanceBTC,
+			BalanceUSD:        cfg.StartBalanceUSD,
+			Inventory:         []OwnedGPU{},
+			Businesses:        []OwnedBusiness{},
 			CreatedAt:         time.Now(),
 			LastAccrualAt:     time.Now(),
-			LastBonusTime:     time.Now().Add(-25 * time.Hour),
-			FarmCapacity:      95,
+			LastBonusTime:     time.Now().Add(-cfg.BonusCooldown.Duration - time.Hour),
+			FarmCapacity:      cfg.FarmCapacity,
 			LastShopMessageID: 0,
 		}
 		store.Users[id] = u
 	}
+	if username != "" && u.Username != username {
+		u.Username = username
+	}
 	return u
 }
 
 func accrueEarnings(u *User) {
 	now := time.Now()
-	if now.Before(u.MiningWindowEnd) {
-		elapsed := now.Sub(u.LastAccrualAt)
-		minutes := elapsed.Minutes()
+	accrued := EarningsAccrued{UserID: u.ID}
+	if !u.LastAccrualAt.IsZero() {
+		active := now.Before(u.MiningWindowEnd)
+		minutes := now.Sub(u.LastAccrualAt).Minutes()
+		accrued.Periods = minutes / 10
+
+		if active {
+			span := now.Sub(u.LastAccrualAt)
+			powered, bill := poweredFor(u, span)
+			u.BalanceUSD -= bill
+			mined := u.MinedBTC
+			creditMining(u, u.LastAccrualAt, u.LastAccrualAt.Add(powered))
+			accrued.MinedBTC = u.MinedBTC - mined
+			if powered < span && bill > 0 {
+				queueNotice(u.ID, "⚡ Не хватило денег на электричество, ферма остановлена. Пополните баланс USD, чтобы она снова заработала")
+			}
 
-		miningIncome := totalMiningRate(u) * (minutes / 10.0)
-		u.BalanceBTC += miningIncome
+			var broke int
+			for i := range u.Inventory {
+				if wearGPU(&u.Inventory[i], powered) {
+					broke++
+				}
+			}
+			if broke > 0 {
+				queueNotice(u.ID, fmt.Sprintf("⚠️ Сломалось видеокарт: %d. Почините их на ферме, иначе они не приносят доход", broke))
+			}
+		}
 
-		businessIncome := totalBusinessIncome(u) * (minutes / 10.0)
-		u.BalanceBTC += businessIncome
+		boost := boostAverage(u, boostBusiness, u.LastAccrualAt, now) * prestigeMultiplier(u)
+		for i := range u.Businesses {
+			accrueBusiness(u, &u.Businesses[i], minutes/10.0, boost)
+		}
 	}
+	pruneBoosts(u, now)
 	u.LastAccrualAt = now
-	u.MiningWindowEnd = now.Add(miningWindow)
+	u.MiningWindowEnd = now.Add(cfg.MiningWindow.Duration)
+	earningsAccrued.Publish(accrued)
 }
 
 func totalMiningRate(u *User) float64 {
 	var rate float64
-	for _, id := range u.Inventory {
-		if g, ok := gpuByID[id]; ok {
-			rate += g.Rate
-		}
+	for _, card := range u.Inventory {
+		rate += gpuRate(card)
 	}
 	return rate
 }
 
-func totalBusinessIncome(u *User) float64 {
+// totalBusinessIncome is what u's businesses earn per 10 minutes in
+// currency, including active boosts.
+func totalBusinessIncome(u *User, currency string) float64 {
 	var income float64
-	for _, id := range u.Businesses {
-		if b, ok := bizByID[id]; ok {
-			income += b.Income
+	for _, ob := range u.Businesses {
+		if bizByID[ob.ID].Currency == currency {
+			income += businessIncome(ob)
 		}
 	}
-	return income
+	return income * boostMultiplier(u, boostBusiness, time.Now()) * prestigeMultiplier(u)
+}
+
+// totalIncomeUSD values all mining and business income per 10 minutes in USD.
+func totalIncomeUSD(u *User) float64 {
+	return miningIncomeUSD(u) + valueUSD(totalBusinessIncome(u, currencyBTC), currencyBTC) + totalBusinessIncome(u, currencyUSD)
 }
 
 func handleMessage(m *tgbotapi.Message) {
+	storeMu.Lock()
+	defer unlockAndNotify()
+
 	u := ensureUser(m.From.ID, m.From.UserName)
 	accrueEarnings(u)
-	saveStore()
+	defer markDirty(u)
 
 	cmd := m.Text
 	if strings.HasPrefix(cmd, "/") {
@@ -238,6 +274,44 @@ func handleMessage(m *tgbotapi.Message) {
 			sendRefInfo(u, m.Chat.ID)
 		case "/business":
 			sendBusinesses(u, m.Chat.ID)
+		case "/config":
+			if isAdmin(u.ID) {
+				sendConfig(m.Chat.ID)
+			} else {
+				sendMainMenu(u, m.Chat.ID)
+			}
+		case "/send":
+			startTransfer(u, parts, m.Chat.ID)
+		case "/prestige":
+			sendPrestige(u, m.Chat.ID)
+		case "/top":
+			sendPlayerLeaderboard(u, m.Chat.ID)
+		case "/transfers":
+			sendTransferHistory(u, m.Chat.ID)
+		case "/market_sell":
+			listGPUCommand(u, parts, m.Chat.ID)
+		case "/bid":
+			placeOrderCommand(u, sideBid, parts, m.Chat.ID)
+		case "/ask":
+			placeOrderCommand(u, sideAsk, parts, m.Chat.ID)
+		case "/exchange":
+			sendExchange(u, m.Chat.ID)
+		case "/clan":
+			sendClan(u, m.Chat.ID)
+		case "/clan_create":
+			createClan(u, parts, m.Chat.ID)
+		case "/clan_join":
+			joinClan(u, parts, m.Chat.ID)
+		case "/clan_leave":
+			leaveClan(u, m.Chat.ID)
+		case "/clan_donate":
+			donateToClan(u, parts, m.Chat.ID)
+		case "/clan_kick":
+			manageClanMember(u, "kick", parts, m.Chat.ID)
+		case "/clan_promote":
+			manageClanMember(u, "promote", parts, m.Chat.ID)
+		case "/clan_demote":
+			manageClanMember(u, "demote", parts, m.Chat.ID)
 		case "/btc_buy":
 			if len(parts) > 1 {
 				amount, _ := strconv.ParseFloat(parts[1], 64)
@@ -261,11 +335,15 @@ func handleMessage(m *tgbotapi.Message) {
 }
 
 func handleCallback(cb *tgbotapi.CallbackQuery) {
+	storeMu.Lock()
+	defer unlockAndNotify()
+
 	u := ensureUser(cb.From.ID, cb.From.UserName)
 	accrueEarnings(u)
+	defer markDirty(u)
 	data := cb.Data
 	chatID := cb.Message.Chat.ID
-	bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	queueSend(tgbotapi.NewCallback(cb.ID, ""), nil)
 
 	switch {
 	case data == "main_menu":
@@ -274,6 +352,18 @@ func handleCallback(cb *tgbotapi.CallbackQuery) {
 	case data == "stats":
 		u.LastShopMessageID = 0
 		sendStats(u, chatID)
+	case data == "prestige":
+		u.LastShopMessageID = 0
+		sendPrestige(u, chatID)
+	case data == "prestige_confirm":
+		u.LastShopMessageID = 0
+		confirmPrestige(u, chatID)
+	case data == "prestige_do":
+		u.LastShopMessageID = 0
+		doPrestige(u, chatID)
+	case data == "top":
+		u.LastShopMessageID = 0
+		sendPlayerLeaderboard(u, chatID)
 	case data == "ref":
 		u.LastShopMessageID = 0
 		sendRefInfo(u, chatID)
@@ -293,15 +383,167 @@ func handleCallback(cb *tgbotapi.CallbackQuery) {
 	case data == "daily_bonus":
 		u.LastShopMessageID = 0
 		claimDailyBonus(u, chatID)
-	case data == "convert_btc_usd":
+	case data == "consumables":
+		u.LastShopMessageID = 0
+		sendConsumables(u, chatID)
+	case strings.HasPrefix(data, "buy_item:"):
+		u.LastShopMessageID = 0
+		buyConsumable(u, strings.TrimPrefix(data, "buy_item:"), chatID)
+	case strings.HasPrefix(data, "use_item:"):
+		u.LastShopMessageID = 0
+		useConsumable(u, strings.TrimPrefix(data, "use_item:"), chatID)
+	case data == "quests":
+		u.LastShopMessageID = 0
+		sendQuests(u, chatID)
+	case data == "achievements":
+		u.LastShopMessageID = 0
+		sendAchievements(u, chatID)
+	case data == "bonus_calendar":
+		u.LastShopMessageID = 0
+		sendBonusCalendar(u, chatID)
+	case strings.HasPrefix(data, "gpu_model:"):
+		u.LastShopMessageID = 0
+		parts := strings.Split(data, ":")
+		id, _ := strconv.Atoi(parts[1])
+		page := 1
+		if len(parts) > 2 {
+			page, _ = strconv.Atoi(parts[2])
+		}
+		sendGPUModel(u, id, page, chatID)
+	case strings.HasPrefix(data, "gpu:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.ParseInt(strings.Split(data, ":")[1], 10, 64)
+		sendGPUCard(u, id, chatID)
+	case strings.HasPrefix(data, "tune_model:"):
+		u.LastShopMessageID = 0
+		parts := strings.Split(data, ":")
+		if len(parts) == 3 {
+			id, _ := strconv.Atoi(parts[1])
+			tuneGPUModel(u, id, parts[2], chatID)
+		}
+	case strings.HasPrefix(data, "tune_gpu:"):
+		u.LastShopMessageID = 0
+		parts := strings.Split(data, ":")
+		if len(parts) == 3 {
+			id, _ := strconv.ParseInt(parts[1], 10, 64)
+			tuneGPU(u, id, parts[2], chatID)
+		}
+	case strings.HasPrefix(data, "repair_gpu:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.ParseInt(strings.Split(data, ":")[1], 10, 64)
+		repairGPU(u, id, chatID)
+	case strings.HasPrefix(data, "sell_gpu:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.ParseInt(strings.Split(data, ":")[1], 10, 64)
+		sellGPU(u, id, chatID)
+	case data == "repair_all":
+		u.LastShopMessageID = 0
+		repairAllGPUs(u, chatID)
+	// convert_btc_usd is the old name, still attached to buttons in sent
+	// messages.
+	case data == "convert_coins_usd", data == "convert_btc_usd":
+		u.LastShopMessageID = 0
+		convertAllCoinsToUSD(u, chatID)
+	case data == "mining":
+		u.LastShopMessageID = 0
+		sendMiningSettings(u, chatID)
+	case strings.HasPrefix(data, "set_coin:"):
+		u.LastShopMessageID = 0
+		setMiningCoin(u, strings.Split(data, ":")[1], chatID)
+	case strings.HasPrefix(data, "set_pool:"):
+		u.LastShopMessageID = 0
+		setMiningPool(u, strings.Split(data, ":")[1], chatID)
+	case strings.HasPrefix(data, "set_gpu_coin:"):
+		u.LastShopMessageID = 0
+		parts := strings.Split(data, ":")
+		if len(parts) == 3 {
+			id, _ := strconv.ParseInt(parts[1], 10, 64)
+			setGPUCoin(u, id, parts[2], chatID)
+		}
+	case strings.HasPrefix(data, "transfer_ok:"):
+		id, _ := strconv.ParseInt(strings.Split(data, ":")[1], 10, 64)
+		confirmTransfer(u, id, chatID)
+	case strings.HasPrefix(data, "transfer_cancel:"):
+		id, _ := strconv.ParseInt(strings.Split(data, ":")[1], 10, 64)
+		cancelTransfer(u, id, chatID)
+	case data == "clan":
+		u.LastShopMessageID = 0
+		sendClan(u, chatID)
+	case data == "clan_top":
+		u.LastShopMessageID = 0
+		sendClanLeaderboard(u, chatID)
+	case data == "clan_upgrade":
+		u.LastShopMessageID = 0
+		upgradeClan(u, chatID)
+	case data == "clan_leave":
+		u.LastShopMessageID = 0
+		leaveClan(u, chatID)
+	case data == "exchange":
 		u.LastShopMessageID = 0
-		convertAllBTCtoUSD(u, chatID)
+		sendExchange(u, chatID)
+	case strings.HasPrefix(data, "cancel_order:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.ParseInt(strings.Split(data, ":")[1], 10, 64)
+		cancelOrder(u, id, chatID)
+	case strings.HasPrefix(data, "market:"):
+		u.LastShopMessageID = 0
+		parts := strings.Split(data, ":")
+		if len(parts) == 3 {
+			id, _ := strconv.Atoi(parts[1])
+			page, _ := strconv.Atoi(parts[2])
+			sendMarket(u, id, page, chatID)
+		}
+	case data == "market_models":
+		u.LastShopMessageID = 0
+		sendMarketModels(u, chatID)
+	case data == "market_mine":
+		u.LastShopMessageID = 0
+		sendMyListings(u, chatID)
+	case strings.HasPrefix(data, "market_buy:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.ParseInt(strings.Split(data, ":")[1], 10, 64)
+		buyListing(u, id, chatID)
+	case strings.HasPrefix(data, "market_cancel:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.ParseInt(strings.Split(data, ":")[1], 10, 64)
+		cancelListing(u, id, chatID)
+	case strings.HasPrefix(data, "list_gpu:"):
+		u.LastShopMessageID = 0
+		parts := strings.Split(data, ":")
+		if len(parts) == 3 {
+			id, _ := strconv.ParseInt(parts[1], 10, 64)
+			price, _ := strconv.ParseFloat(parts[2], 64)
+			listGPU(u, id, price, chatID)
+		}
 	case strings.HasPrefix(data, "buy_gpu:"):
 		id, _ := strconv.Atoi(strings.Split(data, ":")[1])
 		buyGPU(u, id, chatID)
 	case strings.HasPrefix(data, "buy_biz:"):
 		id, _ := strconv.Atoi(strings.Split(data, ":")[1])
 		buyBusiness(u, id, chatID)
+	case strings.HasPrefix(data, "biz:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.Atoi(strings.Split(data, ":")[1])
+		sendBusinessDetail(u, id, chatID)
+	case strings.HasPrefix(data, "hire_manager:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.Atoi(strings.Split(data, ":")[1])
+		hireManager(u, id, chatID)
+	case data == "collect_all":
+		u.LastShopMessageID = 0
+		collectAllBusinesses(u, chatID)
+	case strings.HasPrefix(data, "collect_biz:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.Atoi(strings.Split(data, ":")[1])
+		collectBusiness(u, id, chatID)
+	case strings.HasPrefix(data, "upgrade_vault:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.Atoi(strings.Split(data, ":")[1])
+		upgradeVault(u, id, chatID)
+	case strings.HasPrefix(data, "upgrade_biz:"):
+		u.LastShopMessageID = 0
+		id, _ := strconv.Atoi(strings.Split(data, ":")[1])
+		upgradeBusiness(u, id, chatID)
 	case strings.HasPrefix(data, "gpu_shop_page:"):
 		page, _ := strconv.Atoi(strings.Split(data, ":")[1])
 		sendGPUShop(u, chatID, page)
@@ -309,18 +551,32 @@ func handleCallback(cb *tgbotapi.CallbackQuery) {
 		page, _ := strconv.Atoi(strings.Split(data, ":")[1])
 		sendBusinessShop(u, chatID, page)
 	}
-	saveStore()
 }
 
 func sendMainMenu(u *User, chatID int64) {
 	currentTime := time.Now().Format("15:04")
 	text := fmt.Sprintf("🖥 *Симулятор майнера* 🖥\n\n")
-	text += fmt.Sprintf("• Вместимость фермы: %d/95\n", len(u.Inventory))
-	text += fmt.Sprintf("• Заработок фермы: %.7f BTC / 10 мин\n", totalMiningRate(u))
-	text += fmt.Sprintf("• Доход бизнесов: %.7f BTC / 10 мин\n", totalBusinessIncome(u))
+	text += fmt.Sprintf("• Вместимость фермы: %d/%d\n", len(u.Inventory), u.FarmCapacity)
+	text += fmt.Sprintf("• Заработок фермы: %s / 10 мин (≈ %.2f $)\n", formatYields(miningYields(u)), miningIncomeUSD(u))
+	text += fmt.Sprintf("• Доход бизнесов: %.7f BTC + %.0f $ / 10 мин\n", totalBusinessIncome(u, currencyBTC), totalBusinessIncome(u, currencyUSD))
+	if btc, usd := vaultTotals(u); btc > 0 || usd > 0 {
+		text += fmt.Sprintf("• В хранилищах бизнесов: %.7f BTC + %.0f $\n", btc, usd)
+	}
 	text += fmt.Sprintf("• Баланс: %.5f BTC\n", u.BalanceBTC)
-	text += fmt.Sprintf("• Баланс: %.0f $\n\n", u.BalanceUSD)
-	text += fmt.Sprintf("Курс BTC: %.0f $ / 1 BTC\n\n", btcRate)
+	for _, symbol := range walletSymbols(u) {
+		text += fmt.Sprintf("• Баланс: %s\n", formatCoin(u.Wallet[symbol], symbol))
+	}
+	text += fmt.Sprintf("• Баланс: %.0f $\n", u.BalanceUSD)
+	for _, line := range activeBoostLines(u, time.Now()) {
+		text += fmt.Sprintf("• %s\n", line)
+	}
+	text += "\n"
+	for _, c := range coinCatalog {
+		text += fmt.Sprintf("Курс %s: %s / 1 %s %s\n", c.Symbol, formatPrice(coinPrice(c.Symbol)), c.Symbol, priceTrend(c.Symbol))
+	}
+	text += fmt.Sprintf("Сложность сети: %.2f\n", networkDifficulty())
+	left := blocksToHalving()
+	text += fmt.Sprintf("Блок #%d, до халвинга %d блоков (~%s)\n\n", store.Network.Height, left, formatPayback(time.Duration(left)*cfg.BlockInterval.Duration))
 	text += fmt.Sprintf("%s", currentTime)
 
 	kb := tgbotapi.NewInlineKeyboardMarkup(
@@ -337,7 +593,13 @@ func sendMainMenu(u *User, chatID int64) {
 			tgbotapi.NewInlineKeyboardButtonData("🎁 Ежедневный бонус", "daily_bonus"),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("💸 Вывести BTC в USD", "convert_btc_usd"),
+			tgbotapi.NewInlineKeyboardButtonData("👥 Клан", "clan"),
+			tgbotapi.NewInlineKeyboardButtonData("🏅 Достижения", "achievements"),
+			tgbotapi.NewInlineKeyboardButtonData("📋 Задания", "quests"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💸 Продать монеты за USD", "convert_coins_usd"),
+			tgbotapi.NewInlineKeyboardButtonData("📈 Биржа", "exchange"),
 		),
 	)
 
@@ -348,15 +610,25 @@ func sendStats(u *User, chatID int64) {
 	currentTime := time.Now().Format("15:04")
 	text := fmt.Sprintf("📊 *Личная статистика*\n\n")
 	text += fmt.Sprintf("• Игрок: @%s\n", u.Username)
-	text += fmt.Sprintf("• Видеокарты: %d/95\n", len(u.Inventory))
+	text += fmt.Sprintf("• Видеокарты: %d/%d\n", len(u.Inventory), u.FarmCapacity)
 	text += fmt.Sprintf("• Бизнесы: %d\n", len(u.Businesses))
-	text += fmt.Sprintf("• Общий доход: %.7f BTC / 10 мин\n", totalMiningRate(u)+totalBusinessIncome(u))
+	text += fmt.Sprintf("• Майнинг: %s / 10 мин, пул «%s»\n", formatYields(miningYields(u)), miningPool(u.Pool).Name)
+	text += fmt.Sprintf("• Бизнесы: %.7f BTC + %.0f $ / 10 мин\n", totalBusinessIncome(u, currencyBTC), totalBusinessIncome(u, currencyUSD))
+	text += fmt.Sprintf("• Общий доход: ≈ %.2f $ / 10 мин\n", totalIncomeUSD(u))
 	text += fmt.Sprintf("• Баланс BTC: %.7f\n", u.BalanceBTC)
+	for _, symbol := range walletSymbols(u) {
+		text += fmt.Sprintf("• Баланс %s: %s\n", symbol, formatCoin(u.Wallet[symbol], symbol))
+	}
 	text += fmt.Sprintf("• Баланс USD: %.0f\n", u.BalanceUSD)
+	text += fmt.Sprintf("• Престиж: ⭐ %d, очков %d (доход ×%.2f)\n", u.Prestige, u.PrestigePoints, prestigeMultiplier(u))
 	text += fmt.Sprintf("• Играет с: %s\n", u.CreatedAt.Format("02.01.2006"))
 	text += fmt.Sprintf("\n%s", currentTime)
 
 	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✨ Перерождение", "prestige"),
+			tgbotapi.NewInlineKeyboardButtonData("🏆 Рейтинг", "top"),
+		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
 		),
@@ -393,22 +665,30 @@ func sendBusinesses(u *User, chatID int64) {
 	if len(u.Businesses) == 0 {
 		text += "У вас пока нет бизнесов. Приобретите их в магазине!\n"
 	} else {
-		for i, id := range u.Businesses {
-			if biz, ok := bizByID[id]; ok {
-				text += fmt.Sprintf("%d. %s - %.7f BTC/10мин\n", i+1, biz.Name, biz.Income)
+		for i, ob := range u.Businesses {
+			if biz, ok := bizByID[ob.ID]; ok {
+				manager := ""
+				if ob.Manager {
+					manager = " 👔"
+				}
+				text += fmt.Sprintf("%d. %s (ур. %d)%s - %s/10мин\n", i+1, biz.Name, ob.Level, manager, formatAmount(businessIncome(ob), biz.Currency))
+				text += fmt.Sprintf("   Куплен %s, заработано %s\n", ob.PurchasedAt.Format("02.01.2006"), formatAmount(ob.TotalEarned, biz.Currency))
+				if !ob.Manager {
+					text += fmt.Sprintf("   Хранилище: %s / %s\n", formatAmount(ob.Vault, biz.Currency), formatAmount(vaultCapacity(ob), biz.Currency))
+				}
 			}
 		}
 	}
 
-	text += fmt.Sprintf("\nОбщий доход от бизнесов: %.5f BTC/10мин", totalBusinessIncome(u))
+	text += fmt.Sprintf("\nОбщий доход от бизнесов: %.5f BTC + %.0f $ /10мин", totalBusinessIncome(u, currencyBTC), totalBusinessIncome(u, currencyUSD))
 	text += fmt.Sprintf("\n\n%s", currentTime)
 
-	kb := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🛒 Магазин бизнесов", "business_shop"),
-			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
-		),
-	)
+	kbRows := businessRows(u)
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🛒 Магазин бизнесов", "business_shop"),
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+	))
+	kb := tgbotapi.NewInlineKeyboardMarkup(kbRows...)
 
 	sendMessageWithKeyboard(chatID, text, kb)
 }
@@ -416,28 +696,57 @@ func sendBusinesses(u *User, chatID int64) {
 func sendFarm(u *User, chatID int64) {
 	currentTime := time.Now().Format("15:04")
 	text := fmt.Sprintf("🖥 *Ваша ферма*\n\n")
-	text += fmt.Sprintf("• Вместимость: %d/95\n", len(u.Inventory))
-	text += fmt.Sprintf("• Доход фермы: %.7f BTC/10мин\n", totalMiningRate(u))
+	text += fmt.Sprintf("• Вместимость: %d/%d\n", len(u.Inventory), u.FarmCapacity)
+	pool := miningPool(u.Pool)
+	text += fmt.Sprintf("• Доход фермы: %s/10мин\n", formatYields(miningYields(u)))
+	text += fmt.Sprintf("• Пул: %s (комиссия %.0f%%)\n", pool.Name, pool.Fee*100)
+	watts := totalPowerDraw(u)
+	text += fmt.Sprintf("• Потребление: %.0f Вт (%.2f $/10мин при %.2f $/кВт·ч)\n", watts, electricityCost(watts, 10*time.Minute), cfg.ElectricityPrice)
+	if broken := brokenGPUCount(u); broken > 0 {
+		text += fmt.Sprintf("• Сломано: %d\n", broken)
+	}
+	if !farmPowered(u) {
+		text += "• ⚡ Ферма остановлена: нечем платить за электричество\n"
+	}
 
-	if len(u.Inventory) == 0 {
+	groups := groupGPUs(u)
+	if len(groups) == 0 {
 		text += "\nУ вас пока нет видеокарт. Приобретите их в магазине!"
 	} else {
 		text += "\nУстановленные видеокарты:\n"
-		for i, id := range u.Inventory {
-			if gpu, ok := gpuByID[id]; ok {
-				text += fmt.Sprintf("%d. %s - %.7f BTC/10мин\n", i+1, gpu.Name, gpu.Rate)
+		for i, grp := range groups {
+			text += fmt.Sprintf("%d. %s ×%d - %.7f BTC/10мин, ресурс %.0f%%", i+1, grp.GPU.Name, len(grp.Cards), grp.Rate, grp.AvgWear)
+			if grp.Broken > 0 {
+				text += fmt.Sprintf(", сломано %d", grp.Broken)
 			}
+			text += "\n"
 		}
 	}
 
 	text += fmt.Sprintf("\n%s", currentTime)
 
-	kb := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🛒 Магазин видеокарт", "gpu_shop"),
-			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
-		),
-	)
+	kbRows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for i := 0; i < len(groups); i += 2 {
+		row := make([]tgbotapi.InlineKeyboardButton, 0, 2)
+		for _, grp := range groups[i:min(i+2, len(groups))] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("%s ×%d", grp.GPU.Name, len(grp.Cards)), fmt.Sprintf("gpu_model:%d:1", grp.GPU.ID)))
+		}
+		kbRows = append(kbRows, row)
+	}
+	if cost, count := repairAllCost(u); count > 0 {
+		kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🔧 Починить все (%.0f $)", cost), "repair_all"),
+		))
+	}
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⛏ Монеты и пул", "mining"),
+	))
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🛒 Магазин видеокарт", "gpu_shop"),
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+	))
+	kb := tgbotapi.NewInlineKeyboardMarkup(kbRows...)
 
 	sendMessageWithKeyboard(chatID, text, kb)
 }
@@ -452,6 +761,10 @@ func sendShopMenu(u *User, chatID int64) {
 			tgbotapi.NewInlineKeyboardButtonData("💻 Видеокарты", "gpu_shop"),
 			tgbotapi.NewInlineKeyboardButtonData("🏢 Бизнесы", "business_shop"),
 		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏪 Рынок б/у видеокарт", "market:0:1"),
+			tgbotapi.NewInlineKeyboardButtonData("🧪 Расходники", "consumables"),
+		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
 		),
@@ -462,8 +775,8 @@ func sendShopMenu(u *User, chatID int64) {
 
 func sendGPUShop(u *User, chatID int64, page int) {
 	currentTime := time.Now().Format("15:04")
-	start := (page - 1) * shopPageSize
-	end := start + shopPageSize
+	start := (page - 1) * cfg.ShopPageSize
+	end := start + cfg.ShopPageSize
 	if end > len(gpuCatalog) {
 		end = len(gpuCatalog)
 	}
@@ -471,10 +784,13 @@ func sendGPUShop(u *User, chatID int64, page int) {
 	text := "💻 *Магазин видеокарт*\n\n"
 	for _, gpu := range gpuCatalog[start:end] {
 		text += fmt.Sprintf("%s - %.0f $\n", gpu.Name, gpu.Price)
+		if level := gpuMinPrestige(gpu.ID); level > u.Prestige {
+			text += fmt.Sprintf("🔒 Нужен престиж %d\n", level)
+		}
 		text += fmt.Sprintf("Доход: %.5f BTC/10мин\n\n", gpu.Rate)
 	}
 
-	totalPages := (len(gpuCatalog) + shopPageSize - 1) / shopPageSize
+	totalPages := (len(gpuCatalog) + cfg.ShopPageSize - 1) / cfg.ShopPageSize
 	text += fmt.Sprintf("Страница %d/%d\n\n", page, totalPages)
 	text += fmt.Sprintf("%s", currentTime)
 
@@ -509,19 +825,22 @@ func sendGPUShop(u *User, chatID int64, page int) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = kb
-	sentMsg, err := bot.Send(msg)
-	if err != nil {
-		log.Printf("Ошибка отправки магазина GPU: %v", err)
-		return
-	}
+	queueSend(msg, rememberShopMessage(u))
+}
 
-	u.LastShopMessageID = sentMsg.MessageID
+// rememberShopMessage stores the ID of a sent shop message so that paging
+// edits it in place.
+func rememberShopMessage(u *User) func(tgbotapi.Message) {
+	return func(sent tgbotapi.Message) {
+		u.LastShopMessageID = sent.MessageID
+		markDirty(u)
+	}
 }
 
 func sendBusinessShop(u *User, chatID int64, page int) {
 	currentTime := time.Now().Format("15:04")
-	start := (page - 1) * shopPageSize
-	end := start + shopPageSize
+	start := (page - 1) * cfg.ShopPageSize
+	end := start + cfg.ShopPageSize
 	if end > len(bizCatalog) {
 		end = len(bizCatalog)
 	}
@@ -529,10 +848,14 @@ func sendBusinessShop(u *User, chatID int64, page int) {
 	text := "🏢 *Магазин бизнесов*\n\n"
 	for _, biz := range bizCatalog[start:end] {
 		text += fmt.Sprintf("%s - %.0f $\n", biz.Name, biz.Price)
-		text += fmt.Sprintf("Доход: %.5f BTC/10мин\n\n", biz.Income)
+		text += fmt.Sprintf("Доход: %s/10мин (%s)\n", formatAmount(biz.Income, biz.Currency), incomeTypeLabels[biz.Type])
+		if ob := findBusiness(u, biz.ID); ob != nil {
+			text += fmt.Sprintf("✅ У вас: уровень %d\n", ob.Level)
+		}
+		text += "\n"
 	}
 
-	totalPages := (len(bizCatalog) + shopPageSize - 1) / shopPageSize
+	totalPages := (len(bizCatalog) + cfg.ShopPageSize - 1) / cfg.ShopPageSize
 	text += fmt.Sprintf("Страница %d/%d\n\n", page, totalPages)
 	text += fmt.Sprintf("%s", currentTime)
 
@@ -571,44 +894,10 @@ func sendBusinessShop(u *User, chatID int64, page int) {
 		msg := tgbotapi.NewMessage(chatID, text)
 		msg.ParseMode = "Markdown"
 		msg.ReplyMarkup = kb
-		sentMsg, _ := bot.Send(msg)
-		u.LastShopMessageID = sentMsg.MessageID
+		queueSend(msg, rememberShopMessage(u))
 	}
 }
 
-func claimDailyBonus(u *User, chatID int64) {
-	currentTime := time.Now().Format("15:04")
-	now := time.Now()
-	if now.Sub(u.LastBonusTime) < 24*time.Hour {
-		timeLeft := 24*time.Hour - now.Sub(u.LastBonusTime)
-		text := fmt.Sprintf("🎁 Вы уже получали ежедневный бонус сегодня\n\nСледующий бонус через: %.0f часов\n\n%s", timeLeft.Hours(), currentTime)
-		sendMessage(chatID, text)
-		return
-	}
-
-	bonusBTC := 0.001
-	u.BalanceBTC += bonusBTC
-	u.LastBonusTime = now
-
-	text := fmt.Sprintf("🎁 *Ежедневный бонус получен!*\n\n+%.5f BTC\n\n%s", bonusBTC, currentTime)
-	sendMessage(chatID, text)
-}
-
-func convertAllBTCtoUSD(u *User, chatID int64) {
-	currentTime := time.Now().Format("15:04")
-	if u.BalanceBTC <= 0 {
-		sendMessage(chatID, fmt.Sprintf("У вас нет BTC для конвертации\n\n%s", currentTime))
-		return
-	}
-
-	usdAmount := u.BalanceBTC * btcRate
-	u.BalanceUSD += usdAmount
-	u.BalanceBTC = 0
-
-	text := fmt.Sprintf("💸 *Конвертация завершена*\n\nВы конвертировали все свои BTC в USD\nПолучено: %.0f $\n\n%s", usdAmount, currentTime)
-	sendMessage(chatID, text)
-}
-
 func buyGPU(u *User, id int, chatID int64) {
 	currentTime := time.Now().Format("15:04")
 	gpu, exists := gpuByID[id]
@@ -617,13 +906,18 @@ func buyGPU(u *User, id int, chatID int64) {
 		return
 	}
 
+	if level := gpuMinPrestige(gpu.ID); level > u.Prestige {
+		sendMessage(chatID, fmt.Sprintf("Эта видеокарта доступна с %d уровня престижа\n\n%s", level, currentTime))
+		return
+	}
+
 	if u.BalanceUSD < gpu.Price {
 		sendMessage(chatID, fmt.Sprintf("Недостаточно средств для покупки\n\n%s", currentTime))
 		return
 	}
 
 	if u.FarmCapacity == 0 {
-		u.FarmCapacity = 95
+		u.FarmCapacity = cfg.FarmCapacity
 	}
 
 	if len(u.Inventory) >= u.FarmCapacity {
@@ -632,11 +926,12 @@ func buyGPU(u *User, id int, chatID int64) {
 	}
 
 	u.BalanceUSD -= gpu.Price
-	u.Inventory = append(u.Inventory, id)
+	u.Inventory = append(u.Inventory, newGPU(gpu, gpu.Price))
 
 	text := fmt.Sprintf("✅ *Покупка совершена*\n\nВы приобрели: %s\nПотрачено: %.0f $\nДоход: %.5f BTC/10мин\n\n%s",
 		gpu.Name, gpu.Price, gpu.Rate, currentTime)
 	sendMessage(chatID, text)
+	gpuPurchased.Publish(GPUPurchased{UserID: u.ID, GPUID: gpu.ID, Price: gpu.Price})
 
 	sendGPUShop(u, chatID, 1)
 }
@@ -649,31 +944,30 @@ func buyBusiness(u *User, id int, chatID int64) {
 		return
 	}
 
-	if u.BalanceUSD < biz.Price {
-		sendMessage(chatID, fmt.Sprintf("Недостаточно средств для покупки\n\n%s", currentTime))
+	if findBusiness(u, id) != nil {
+		sendMessage(chatID, fmt.Sprintf("У вас уже есть этот бизнес. Улучшить его можно в разделе «Бизнесы»\n\n%s", currentTime))
 		return
 	}
 
-	for _, bizID := range u.Businesses {
-		if bizID == id {
-			sendMessage(chatID, fmt.Sprintf("У вас уже есть этот бизнес\n\n%s", currentTime))
-			return
-		}
+	if u.BalanceUSD < biz.Price {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно средств для покупки\n\n%s", currentTime))
+		return
 	}
 
 	u.BalanceUSD -= biz.Price
-	u.Businesses = append(u.Businesses, id)
+	u.Businesses = append(u.Businesses, OwnedBusiness{ID: id, Level: 1, PurchasedAt: time.Now()})
 
-	text := fmt.Sprintf("✅ *Покупка совершена*\n\nВы приобрели: %s\nПотрачено: %.0f $\nДоход: %.5f BTC/10мин\n\n%s",
-		biz.Name, biz.Price, biz.Income, currentTime)
+	text := fmt.Sprintf("✅ *Покупка совершена*\n\nВы приобрели: %s\nПотрачено: %.0f $\nДоход: %s/10мин\n\n%s",
+		biz.Name, biz.Price, formatAmount(biz.Income, biz.Currency), currentTime)
 	sendMessage(chatID, text)
+	businessPurchased.Publish(BusinessPurchased{UserID: u.ID, BusinessID: id, Price: biz.Price})
 
 	sendBusinessShop(u, chatID, 1)
 }
 
 func buyBTC(u *User, amount float64, chatID int64) {
 	currentTime := time.Now().Format("15:04")
-	cost := amount * btcRate
+	cost := amount * coinPrice(coinBTC)
 	if u.BalanceUSD < cost {
 		sendMessage(chatID, fmt.Sprintf("Недостаточно USD для покупки BTC\n\n%s", currentTime))
 		return
@@ -684,6 +978,7 @@ func buyBTC(u *User, amount float64, chatID int64) {
 
 	text := fmt.Sprintf("✅ *Покупка BTC совершена*\n\nКуплено: %.5f BTC\nПотрачено: %.0f $\n\n%s", amount, cost, currentTime)
 	sendMessage(chatID, text)
+	btcTraded.Publish(BTCTraded{UserID: u.ID, Side: sideBid, Amount: amount, Price: coinPrice(coinBTC), Venue: venueShop})
 }
 
 func sellBTC(u *User, amount float64, chatID int64) {
@@ -693,109 +988,200 @@ func sellBTC(u *User, amount float64, chatID int64) {
 		return
 	}
 
-	income := amount * btcRate
+	income := amount * coinPrice(coinBTC)
 	u.BalanceBTC -= amount
 	u.BalanceUSD += income
 
 	text := fmt.Sprintf("✅ *Продажа BTC совершена*\n\nПродано: %.5f BTC\nПолучено: %.0f $\n\n%s", amount, income, currentTime)
 	sendMessage(chatID, text)
+	btcTraded.Publish(BTCTraded{UserID: u.ID, Side: sideAsk, Amount: amount, Price: coinPrice(coinBTC), Venue: venueShop})
 }
 
-func sendMessage(chatID int64, text string) {
+// notice is a message to a player produced while storeMu is held, such as a
+// breakdown or achievement alert.
+type notice struct {
+	ChatID int64
+	Text   string
+}
+
+// outgoing is a Telegram request made while storeMu is held. sent, if set,
+// runs under storeMu with the delivered message.
+type outgoing struct {
+	req  tgbotapi.Chattable
+	sent func(tgbotapi.Message)
+}
+
+var (
+	outbox         []outgoing
+	pendingNotices []notice
+)
+
+// queueSend holds req until storeMu is released, so that a slow Telegram API
+// does not stall the game. The caller must hold storeMu.
+func queueSend(req tgbotapi.Chattable, sent func(tgbotapi.Message)) {
+	outbox = append(outbox, outgoing{req: req, sent: sent})
+}
+
+// queueNotice schedules text for another player than the one being served.
+// Notices go out after the replies of the current update. The caller must
+// hold storeMu.
+func queueNotice(chatID int64, text string) {
+	pendingNotices = append(pendingNotices, notice{ChatID: chatID, Text: text})
+}
+
+// unlockAndNotify releases storeMu and then sends the replies and notices
+// queued while it was held.
+func unlockAndNotify() {
+	queued, notices := outbox, pendingNotices
+	outbox, pendingNotices = nil, nil
+	storeMu.Unlock()
+	for _, o := range queued {
+		deliver(o)
+	}
+	for _, n := range notices {
+		deliver(outgoing{req: markdownMessage(n.ChatID, n.Text)})
+	}
+}
+
+// deliver performs one request. It must not be called with storeMu held.
+func deliver(o outgoing) {
+	if o.sent == nil {
+		if _, err := bot.Request(o.req); err != nil {
+			log.Printf("Telegram request failed: %v", err)
+		}
+		return
+	}
+	sent, err := bot.Send(o.req)
+	if err != nil {
+		log.Printf("Telegram send failed: %v", err)
+		return
+	}
+	storeMu.Lock()
+	o.sent(sent)
+	storeMu.Unlock()
+}
+
+func markdownMessage(chatID int64, text string) tgbotapi.MessageConfig {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ParseMode = "Markdown"
-	bot.Send(msg)
+	return msg
+}
+
+// sendMessage, sendMessageWithKeyboard and editMessage queue their request;
+// the caller must hold storeMu and release it with unlockAndNotify.
+func sendMessage(chatID int64, text string) {
+	queueSend(markdownMessage(chatID, text), nil)
 }
 
 func sendMessageWithKeyboard(chatID int64, text string, kb tgbotapi.InlineKeyboardMarkup) {
-	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ParseMode = "Markdown"
+	msg := markdownMessage(chatID, text)
 	msg.ReplyMarkup = kb
-	bot.Send(msg)
+	queueSend(msg, nil)
 }
 
 func editMessage(chatID int64, messageID int, text string, kb tgbotapi.InlineKeyboardMarkup) {
 	msg := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, kb)
 	msg.ParseMode = "Markdown"
-	bot.Send(msg)
+	queueSend(msg, nil)
+}
+
+func initCatalogs() {
+	gpuCatalog = append(buildGPUCatalog(), buildPrestigeGPUs()...)
+	bizCatalog = buildBusinessCatalog()
+	coinCatalog = buildCoinCatalog()
+
+	gpuByID = make(map[int]GPU)
+	for _, g := range gpuCatalog {
+		gpuByID[g.ID] = g
+	}
+
+	bizByID = make(map[int]Business)
+	for _, b := range bizCatalog {
+		bizByID[b.ID] = b
+	}
+
+	coinBySymbol = make(map[string]Coin)
+	for _, c := range coinCatalog {
+		coinBySymbol[c.Symbol] = c
+	}
 }
 
 func buildGPUCatalog() []GPU {
 	return []GPU{
-		{1, "GeForce GT 710 1GB", 0.0000010, 50},
-		{2, "GeForce GT 730 2GB", 0.0000018, 90},
-		{3, "GeForce GTX 750 Ti", 0.0000035, 150},
-		{4, "GeForce GTX 950", 0.0000070, 300},
-		{5, "GeForce GTX 960", 0.0000120, 500},
-		{6, "GeForce GTX 970", 0.0000200, 800},
-		{7, "GeForce GTX 980", 0.0000300, 1200},
-		{8, "GeForce GTX 1050 Ti", 0.0000450, 1800},
-		{9, "GeForce GTX 1060 3GB", 0.0000700, 2800},
-		{10, "GeForce GTX 1060 6GB", 0.0000900, 3600},
-		{11, "GeForce GTX 1070", 0.0001300, 5200},
-		{12, "GeForce GTX 1070 Ti", 0.0001500, 6000},
-		{13, "GeForce GTX 1080", 0.0001800, 7200},
-		{14, "GeForce GTX 1080 Ti", 0.0002500, 10000},
-		{15, "GeForce RTX 2060", 0.0003000, 12000},
-		{16, "GeForce RTX 2060 Super", 0.0003500, 14000},
-		{17, "GeForce RTX 2070", 0.0004000, 16000},
-		{18, "GeForce RTX 2070 Super", 0.0004500, 18000},
-		{19, "GeForce RTX 2080", 0.0005000, 20000},
-		{20, "GeForce RTX 2080 Super", 0.0005500, 22000},
-		{21, "GeForce RTX 2080 Ti", 0.0007000, 28000},
-		{22, "GeForce RTX 3050", 0.0008000, 32000},
-		{23, "GeForce RTX 3060", 0.0010000, 40000},
-		{24, "GeForce RTX 3060 Ti", 0.0012000, 48000},
-		{25, "GeForce RTX 3070", 0.0015000, 60000},
-		{26, "GeForce RTX 3070 Ti", 0.0017000, 68000},
-		{27, "GeForce RTX 3080 10GB", 0.0020000, 80000},
-		{28, "GeForce RTX 3080 12GB", 0.0022000, 88000},
-		{29, "GeForce RTX 3080 Ti", 0.0025000, 100000},
-		{30, "GeForce RTX 3090", 0.0030000, 120000},
-		{31, "GeForce RTX 3090 Ti", 0.0035000, 140000},
-		{32, "GeForce RTX 4060", 0.0040000, 160000},
-		{33, "GeForce RTX 4060 Ti", 0.0045000, 180000},
-		{34, "GeForce RTX 4070", 0.0050000, 200000},
-		{35, "GeForce RTX 4070 Ti", 0.0060000, 240000},
-		{36, "GeForce RTX 4080", 0.0075000, 300000},
-		{37, "GeForce RTX 4080 Super", 0.0080000, 320000},
-		{38, "GeForce RTX 4090", 0.0100000, 400000},
-		{39, "GeForce RTX 4090 Ti", 0.0120000, 480000},
-		{40, "Radeon RX 460", 0.0000050, 200},
-		{41, "Radeon RX 470", 0.0000150, 600},
-		{42, "Radeon RX 480", 0.0000250, 1000},
-		{43, "Radeon RX 550", 0.0000080, 320},
-		{44, "Radeon RX 560", 0.0000120, 480},
-		{45, "Radeon RX 570", 0.0000300, 1200},
-		{46, "Radeon RX 580", 0.0000450, 1800},
-		{47, "Radeon RX 590", 0.0000600, 2400},
-		{48, "Radeon RX Vega 56", 0.0001000, 4000},
-		{49, "Radeon RX Vega 64", 0.0001300, 5200},
-		{50, "Radeon VII", 0.0002000, 8000},
-		{51, "Radeon RX 5500 XT", 0.0002500, 10000},
-		{52, "Radeon RX 5600 XT", 0.0003000, 12000},
-		{53, "Radeon RX 5700", 0.0003500, 14000},
-		{54, "Radeon RX 5700 XT", 0.0004000, 16000},
-		{55, "Radeon RX 6600", 0.0005000, 20000},
-		{56, "Radeon RX 6600 XT", 0.0006000, 24000},
-		{57, "Radeon RX 6700 XT", 0.0008000, 32000},
-		{58, "Radeon RX 6800", 0.0010000, 40000},
-		{59, "Radeon RX 6800 XT", 0.0012000, 48000},
-		{60, "Radeon RX 6900 XT", 0.0015000, 60000},
+		{1, "GeForce GT 710 1GB", 0.0000010, 50, 19},
+		{2, "GeForce GT 730 2GB", 0.0000018, 90, 38},
+		{3, "GeForce GTX 750 Ti", 0.0000035, 150, 60},
+		{4, "GeForce GTX 950", 0.0000070, 300, 90},
+		{5, "GeForce GTX 960", 0.0000120, 500, 120},
+		{6, "GeForce GTX 970", 0.0000200, 800, 145},
+		{7, "GeForce GTX 980", 0.0000300, 1200, 165},
+		{8, "GeForce GTX 1050 Ti", 0.0000450, 1800, 75},
+		{9, "GeForce GTX 1060 3GB", 0.0000700, 2800, 120},
+		{10, "GeForce GTX 1060 6GB", 0.0000900, 3600, 120},
+		{11, "GeForce GTX 1070", 0.0001300, 5200, 150},
+		{12, "GeForce GTX 1070 Ti", 0.0001500, 6000, 180},
+		{13, "GeForce GTX 1080", 0.0001800, 7200, 180},
+		{14, "GeForce GTX 1080 Ti", 0.0002500, 10000, 250},
+		{15, "GeForce RTX 2060", 0.0003000, 12000, 160},
+		{16, "GeForce RTX 2060 Super", 0.0003500, 14000, 175},
+		{17, "GeForce RTX 2070", 0.0004000, 16000, 175},
+		{18, "GeForce RTX 2070 Super", 0.0004500, 18000, 215},
+		{19, "GeForce RTX 2080", 0.0005000, 20000, 215},
+		{20, "GeForce RTX 2080 Super", 0.0005500, 22000, 250},
+		{21, "GeForce RTX 2080 Ti", 0.0007000, 28000, 250},
+		{22, "GeForce RTX 3050", 0.0008000, 32000, 130},
+		{23, "GeForce RTX 3060", 0.0010000, 40000, 170},
+		{24, "GeForce RTX 3060 Ti", 0.0012000, 48000, 200},
+		{25, "GeForce RTX 3070", 0.0015000, 60000, 220},
+		{26, "GeForce RTX 3070 Ti", 0.0017000, 68000, 290},
+		{27, "GeForce RTX 3080 10GB", 0.0020000, 80000, 320},
+		{28, "GeForce RTX 3080 12GB", 0.0022000, 88000, 350},
+		{29, "GeForce RTX 3080 Ti", 0.0025000, 100000, 350},
+		{30, "GeForce RTX 3090", 0.0030000, 120000, 350},
+		{31, "GeForce RTX 3090 Ti", 0.0035000, 140000, 450},
+		{32, "GeForce RTX 4060", 0.0040000, 160000, 115},
+		{33, "GeForce RTX 4060 Ti", 0.0045000, 180000, 160},
+		{34, "GeForce RTX 4070", 0.0050000, 200000, 200},
+		{35, "GeForce RTX 4070 Ti", 0.0060000, 240000, 285},
+		{36, "GeForce RTX 4080", 0.0075000, 300000, 320},
+		{37, "GeForce RTX 4080 Super", 0.0080000, 320000, 320},
+		{38, "GeForce RTX 4090", 0.0100000, 400000, 450},
+		{39, "GeForce RTX 4090 Ti", 0.0120000, 480000, 600},
+		{40, "Radeon RX 460", 0.0000050, 200, 75},
+		{41, "Radeon RX 470", 0.0000150, 600, 120},
+		{42, "Radeon RX 480", 0.0000250, 1000, 150},
+		{43, "Radeon RX 550", 0.0000080, 320, 50},
+		{44, "Radeon RX 560", 0.0000120, 480, 80},
+		{45, "Radeon RX 570", 0.0000300, 1200, 150},
+		{46, "Radeon RX 580", 0.0000450, 1800, 185},
+		{47, "Radeon RX 590", 0.0000600, 2400, 225},
+		{48, "Radeon RX Vega 56", 0.0001000, 4000, 210},
+		{49, "Radeon RX Vega 64", 0.0001300, 5200, 295},
+		{50, "Radeon VII", 0.0002000, 8000, 300},
+		{51, "Radeon RX 5500 XT", 0.0002500, 10000, 130},
+		{52, "Radeon RX 5600 XT", 0.0003000, 12000, 150},
+		{53, "Radeon RX 5700", 0.0003500, 14000, 180},
+		{54, "Radeon RX 5700 XT", 0.0004000, 16000, 225},
+		{55, "Radeon RX 6600", 0.0005000, 20000, 132},
+		{56, "Radeon RX 6600 XT", 0.0006000, 24000, 160},
+		{57, "Radeon RX 6700 XT", 0.0008000, 32000, 230},
+		{58, "Radeon RX 6800", 0.0010000, 40000, 250},
+		{59, "Radeon RX 6800 XT", 0.0012000, 48000, 300},
+		{60, "Radeon RX 6900 XT", 0.0015000, 60000, 300},
 	}
 }
 
 func buildBusinessCatalog() []Business {
 	return []Business{
-		{1, "Небольшая ферма", 0.005, 5000},
-		{2, "Средняя ферма", 0.015, 15000},
-		{3, "Крупная ферма", 0.030, 30000},
-		{4, "Криптообменник", 0.050, 50000},
-		{5, "Майнинг-отель", 0.100, 100000},
-		{6, "Криптофонд", 0.200, 200000},
-		{7, "Блокчейн стартап", 0.500, 500000},
-		{8, "Криптобиржа", 1.000, 1000000},
-		{9, "Международная майнинговая компания", 2.000, 2000000},
-		{10, "Глобальный блокчейн-холдинг", 5.000, 5000000},
+		{1, "Небольшая ферма", 0.005, 5000, currencyBTC, incomeMining},
+		{2, "Средняя ферма", 0.015, 15000, currencyBTC, incomeMining},
+		{3, "Крупная ферма", 0.030, 30000, currencyBTC, incomeMining},
+		{4, "Криптообменник", 5650, 50000, currencyUSD, incomeFees},
+		{5, "Майнинг-отель", 11300, 100000, currencyUSD, incomeRent},
+		{6, "Криптофонд", 0.200, 200000, currencyBTC, incomeSpread},
+		{7, "Блокчейн стартап", 56500, 500000, currencyUSD, incomeFees},
+		{8, "Криптобиржа", 113000, 1000000, currencyUSD, incomeFees},
+		{9, "Международная майнинговая компания", 2.000, 2000000, currencyBTC, incomeMining},
+		{10, "Глобальный блокчейн-холдинг", 5.000, 5000000, currencyBTC, incomeSpread},
 	}
 }
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	marketPageSize    = 8
+	marketMaxListings = 20
+	marketMinPrice    = 1
+)
+
+// marketPriceSteps are the listing prices offered as buttons, as a share of
+// the catalog price scaled by the card's durability.
+var marketPriceSteps = []float64{0.5, 0.7, 0.9}
+
+// Listing is a card offered on the player market. The card is held in escrow:
+// it leaves the seller's inventory when listed and goes back on cancel.
+type Listing struct {
+	ID       int64     `json:"id"`
+	Seller   int64     `json:"seller"`
+	Card     OwnedGPU  `json:"card"`
+	Price    float64   `json:"price"`
+	ListedAt time.Time `json:"listed_at"`
+}
+
+func findListing(id int64) (int, *Listing) {
+	for i := range store.Listings {
+		if store.Listings[i].ID == id {
+			return i, &store.Listings[i]
+		}
+	}
+	return -1, nil
+}
+
+func removeListing(index int) Listing {
+	l := store.Listings[index]
+	store.Listings = append(store.Listings[:index], store.Listings[index+1:]...)
+	return l
+}
+
+func userListings(u *User) []Listing {
+	var listings []Listing
+	for _, l := range store.Listings {
+		if l.Seller == u.ID {
+			listings = append(listings, l)
+		}
+	}
+	return listings
+}
+
+func marketFee(price float64) float64 {
+	return price * cfg.MarketFeeRate
+}
+
+// suggestedPrice is a listing price at the given share of what the card is
+// worth new, discounted by its wear.
+func suggestedPrice(card OwnedGPU, share float64) float64 {
+	return math.Max(marketMinPrice, math.Round(gpuByID[card.GPUID].Price*share*card.Durability/maxDurability))
+}
+
+func listGPU(u *User, instanceID int64, price float64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	i, card := findGPU(u, instanceID)
+	if card == nil {
+		sendMessage(chatID, fmt.Sprintf("Видеокарта #%d не найдена\n\n%s", instanceID, currentTime))
+		return
+	}
+	if card.Broken {
+		sendMessage(chatID, fmt.Sprintf("Сломанную видеокарту нельзя выставить на рынок, сначала почините её\n\n%s", currentTime))
+		return
+	}
+	if !(price >= marketMinPrice) {
+		sendMessage(chatID, fmt.Sprintf("Цена должна быть не меньше %d $\n\n%s", marketMinPrice, currentTime))
+		return
+	}
+	if len(userListings(u)) >= marketMaxListings {
+		sendMessage(chatID, fmt.Sprintf("Нельзя выставить больше %d лотов одновременно\n\n%s", marketMaxListings, currentTime))
+		return
+	}
+
+	listed := removeGPU(u, i)
+	store.NextListingID++
+	store.Listings = append(store.Listings, Listing{
+		ID:       store.NextListingID,
+		Seller:   u.ID,
+		Card:     listed,
+		Price:    price,
+		ListedAt: time.Now(),
+	})
+
+	sendMessage(chatID, fmt.Sprintf("🏷 *Лот #%d выставлен*\n\n%s #%d за %.0f $\nПосле продажи вы получите %.0f $ (комиссия рынка %.0f%%)\n\n%s",
+		store.NextListingID, gpuByID[listed.GPUID].Name, listed.InstanceID, price, price-marketFee(price), cfg.MarketFeeRate*100, currentTime))
+	sendMyListings(u, chatID)
+}
+
+// listGPUCommand handles "/market_sell <номер карты> <цена>".
+func listGPUCommand(u *User, parts []string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if len(parts) != 3 {
+		sendMessage(chatID, fmt.Sprintf("Используйте: /market_sell [номер видеокарты] [цена в $]\n\n%s", currentTime))
+		return
+	}
+	id, err1 := strconv.ParseInt(strings.TrimPrefix(parts[1], "#"), 10, 64)
+	price, err2 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil {
+		sendMessage(chatID, fmt.Sprintf("Используйте: /market_sell [номер видеокарты] [цена в $]\n\n%s", currentTime))
+		return
+	}
+	listGPU(u, id, price, chatID)
+}
+
+// buyListing settles a market purchase: the buyer pays, the seller is paid
+// minus the market fee and the escrowed card moves to the buyer, all under
+// storeMu.
+func buyListing(u *User, id int64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	i, l := findListing(id)
+	if l == nil {
+		sendMessage(chatID, fmt.Sprintf("Лот #%d уже продан или снят с продажи\n\n%s", id, currentTime))
+		return
+	}
+	if l.Seller == u.ID {
+		sendMessage(chatID, fmt.Sprintf("Это ваш лот. Снять его можно в разделе «Мои лоты»\n\n%s", currentTime))
+		return
+	}
+	if u.BalanceUSD < l.Price {
+		sendMessage(chatID, fmt.Sprintf("Недостаточно средств для покупки\n\n%s", currentTime))
+		return
+	}
+	if len(u.Inventory) >= u.FarmCapacity {
+		sendMessage(chatID, fmt.Sprintf("Достигнут лимит фермы. Нельзя купить больше видеокарт\n\n%s", currentTime))
+		return
+	}
+	if level := gpuMinPrestige(l.Card.GPUID); level > u.Prestige {
+		sendMessage(chatID, fmt.Sprintf("Эта видеокарта доступна с %d уровня престижа\n\n%s", level, currentTime))
+		return
+	}
+	seller, ok := store.Users[l.Seller]
+	if !ok {
+		sendMessage(chatID, fmt.Sprintf("Продавец не найден\n\n%s", currentTime))
+		return
+	}
+
+	sold := removeListing(i)
+	fee := marketFee(sold.Price)
+	u.BalanceUSD -= sold.Price
+	seller.BalanceUSD += sold.Price - fee
+	card := sold.Card
+	card.PurchasePrice = sold.Price
+	card.PurchasedAt = time.Now()
+	card.Coin = ""
+	u.Inventory = append(u.Inventory, card)
+	markDirty(seller)
+	log.Printf("Listing %d: GPU instance %d sold by %d to %d for %.2f (fee %.2f)", sold.ID, card.InstanceID, seller.ID, u.ID, sold.Price, fee)
+
+	name := gpuByID[card.GPUID].Name
+	sendMessage(chatID, fmt.Sprintf("✅ *Покупка совершена*\n\nВы купили на рынке: %s #%d\nПотрачено: %.0f $\n\n%s", name, card.InstanceID, sold.Price, currentTime))
+	queueNotice(seller.ID, fmt.Sprintf("🏷 *Лот #%d продан*\n\n%s #%d купил @%s\nПолучено: %.0f $ (комиссия %.0f $)\n\n%s",
+		sold.ID, name, card.InstanceID, u.Username, sold.Price-fee, fee, currentTime))
+	gpuPurchased.Publish(GPUPurchased{UserID: u.ID, GPUID: card.GPUID, Price: sold.Price, Seller: seller.ID})
+}
+
+func cancelListing(u *User, id int64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	i, l := findListing(id)
+	if l == nil || l.Seller != u.ID {
+		sendMessage(chatID, fmt.Sprintf("Лот #%d не найден\n\n%s", id, currentTime))
+		return
+	}
+	if len(u.Inventory) >= u.FarmCapacity {
+		sendMessage(chatID, fmt.Sprintf("На ферме нет места, чтобы вернуть видеокарту\n\n%s", currentTime))
+		return
+	}
+	listed := removeListing(i)
+	u.Inventory = append(u.Inventory, listed.Card)
+	sendMessage(chatID, fmt.Sprintf("Лот #%d снят, видеокарта вернулась на ферму\n\n%s", id, currentTime))
+	sendMyListings(u, chatID)
+}
+
+// sendMarket shows listings cheapest first, optionally only of one GPU model
+// (gpuID 0 shows all).
+func sendMarket(u *User, gpuID, page int, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	var listings []Listing
+	for _, l := range store.Listings {
+		if gpuID == 0 || l.Card.GPUID == gpuID {
+			listings = append(listings, l)
+		}
+	}
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].Price != listings[j].Price {
+			return listings[i].Price < listings[j].Price
+		}
+		return listings[i].ID < listings[j].ID
+	})
+
+	totalPages := max(1, (len(listings)+marketPageSize-1)/marketPageSize)
+	if page < 1 || page > totalPages {
+		page = 1
+	}
+	start := (page - 1) * marketPageSize
+	end := min(start+marketPageSize, len(listings))
+
+	text := "🏪 *Рынок видеокарт*\n\n"
+	if g, ok := gpuByID[gpuID]; ok {
+		text += fmt.Sprintf("Фильтр: %s\n\n", g.Name)
+	}
+	if len(listings) == 0 {
+		text += "Лотов пока нет\n"
+	}
+	kbRows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for _, l := range listings[start:end] {
+		g := gpuByID[l.Card.GPUID]
+		text += fmt.Sprintf("#%d %s — %.0f $\n", l.ID, g.Name, l.Price)
+		text += fmt.Sprintf("   ресурс %.0f%%, %s, продавец @%s (новая %.0f $)\n", l.Card.Durability, tuningProfile(l.Card.Profile).Name, usernameOf(l.Seller), g.Price)
+		kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Купить #%d за %.0f $", l.ID, l.Price), fmt.Sprintf("market_buy:%d", l.ID)),
+		))
+	}
+	text += fmt.Sprintf("\nКомиссия рынка: %.0f%% с продавца\n", cfg.MarketFeeRate*100)
+	text += fmt.Sprintf("Страница %d/%d\n\n%s", page, totalPages, currentTime)
+
+	navRow := make([]tgbotapi.InlineKeyboardButton, 0)
+	if page > 1 {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("⬅️", fmt.Sprintf("market:%d:%d", gpuID, page-1)))
+	}
+	if end < len(listings) {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("market:%d:%d", gpuID, page+1)))
+	}
+	if len(navRow) > 0 {
+		kbRows = append(kbRows, navRow)
+	}
+	filterLabel := "🔎 Фильтр по модели"
+	if gpuID != 0 {
+		filterLabel = "🔎 Другая модель"
+	}
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(filterLabel, "market_models"),
+		tgbotapi.NewInlineKeyboardButtonData("🏷 Мои лоты", "market_mine"),
+	))
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "shop"),
+	))
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
+
+// sendMarketModels offers a filter button for every model that has listings.
+func sendMarketModels(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	counts := map[int]int{}
+	for _, l := range store.Listings {
+		counts[l.Card.GPUID]++
+	}
+	ids := make([]int, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	text := "🔎 *Фильтр рынка*\n\nВыберите модель:"
+	if len(ids) == 0 {
+		text = "🔎 *Фильтр рынка*\n\nЛотов пока нет"
+	}
+	text += fmt.Sprintf("\n\n%s", currentTime)
+
+	kbRows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for i := 0; i < len(ids); i += 2 {
+		row := make([]tgbotapi.InlineKeyboardButton, 0, 2)
+		for _, id := range ids[i:min(i+2, len(ids))] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("%s (%d)", gpuByID[id].Name, counts[id]), fmt.Sprintf("market:%d:1", id)))
+		}
+		kbRows = append(kbRows, row)
+	}
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Все модели", "market:0:1"),
+	))
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
+
+func sendMyListings(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	listings := userListings(u)
+	text := "🏷 *Мои лоты*\n\n"
+	if len(listings) == 0 {
+		text += "У вас нет лотов. Выставить видеокарту можно с её страницы на ферме или командой /market_sell [номер] [цена]\n"
+	}
+	kbRows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for _, l := range listings {
+		text += fmt.Sprintf("#%d %s #%d — %.0f $, выставлен %s\n", l.ID, gpuByID[l.Card.GPUID].Name, l.Card.InstanceID, l.Price, l.ListedAt.Format("02.01 15:04"))
+		kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("❌ Снять #%d", l.ID), fmt.Sprintf("market_cancel:%d", l.ID)),
+		))
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🏪 Рынок", "market:0:1"),
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+	))
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+type migration struct {
+	version int
+	name    string
+	apply   func(doc map[string]any) error
+}
+
+// migrations must stay ordered by version; the last entry defines the
+// schema version written by this build.
+var migrations = []migration{
+	{1, "normalize user defaults", migrateNormalizeUsers},
+	{2, "business levels", migrateBusinessLevels},
+	{3, "USD business payouts", migrateUSDBusinessEarnings},
+	{4, "GPU durability", migrateGPURecords},
+	{5, "GPU instance IDs", migrateGPUInstanceIDs},
+}
+
+func currentSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// loadOrInitStore loads the users file into store, migrating it to the current
+// schema. The original file is copied aside before a migrated version is
+// written back.
+func loadOrInitStore() error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	data, err := os.ReadFile(usersFile)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(bytes.TrimSpace(data)) == 0) {
+		store = Store{SchemaVersion: currentSchemaVersion(), Users: map[int64]*User{}}
+		return writeStoreFile()
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", usersFile, err)
+	}
+
+	loaded, version, err := decodeStore(data)
+	if err != nil {
+		return err
+	}
+	store = loaded
+	applyWorldFile(&store)
+	if version == store.SchemaVersion {
+		return nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", usersFile, version, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.WriteFile(backup, data, 0o644); err != nil {
+		return fmt.Errorf("back up %s before migration: %w", usersFile, err)
+	}
+	log.Printf("Backed up %s to %s before migrating", usersFile, backup)
+	return writeStoreFile()
+}
+
+// readStoreFile returns the migrated users file without modifying anything on
+// disk. Like loadOrInitStore, it treats a missing or blank file as an empty
+// store.
+func readStoreFile() (Store, error) {
+	data, err := os.ReadFile(usersFile)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(bytes.TrimSpace(data)) == 0) {
+		return Store{SchemaVersion: currentSchemaVersion(), Users: map[int64]*User{}}, nil
+	}
+	if err != nil {
+		return Store{}, err
+	}
+	s, _, err := decodeStore(data)
+	if err == nil {
+		applyWorldFile(&s)
+	}
+	return s, err
+}
+
+// decodeStore migrates raw store JSON in memory and decodes it. The returned
+// version is the schema version found in data.
+func decodeStore(data []byte) (Store, int, error) {
+	migrated, version, err := migrateStoreData(data)
+	if err != nil {
+		return Store{}, 0, err
+	}
+	var s Store
+	if err := json.Unmarshal(migrated, &s); err != nil {
+		return Store{}, 0, fmt.Errorf("decode %s: %w", usersFile, err)
+	}
+	if s.Users == nil {
+		s.Users = map[int64]*User{}
+	}
+	return s, version, nil
+}
+
+// migrateStoreData upgrades raw store JSON to the current schema version.
+func migrateStoreData(data []byte) ([]byte, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, 0, fmt.Errorf("%s is corrupt: %w", usersFile, err)
+	}
+
+	version := 0
+	if raw, ok := doc["schema_version"]; ok {
+		n, ok := raw.(json.Number)
+		if !ok {
+			return nil, 0, fmt.Errorf("%s has invalid schema_version %v", usersFile, raw)
+		}
+		v, err := n.Int64()
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s has invalid schema_version %v", usersFile, raw)
+		}
+		version = int(v)
+	}
+
+	target := currentSchemaVersion()
+	if version > target {
+		return nil, 0, fmt.Errorf("%s has schema version %d, this build supports up to %d", usersFile, version, target)
+	}
+	if version == target {
+		return data, version, nil
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if err := m.apply(doc); err != nil {
+			return nil, 0, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		doc["schema_version"] = m.version
+		log.Printf("Applied store migration %d: %s", m.version, m.name)
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, version, nil
+}
+
+// writeStoreFile writes the store synchronously. The caller must hold storeMu.
+func writeStoreFile() error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(usersFile, data)
+}
+
+func docUsers(doc map[string]any) (map[string]any, error) {
+	raw, ok := doc["users"]
+	if !ok || raw == nil {
+		doc["users"] = map[string]any{}
+		return doc["users"].(map[string]any), nil
+	}
+	users, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("users is %T, want object", raw)
+	}
+	return users, nil
+}
+
+func migrateNormalizeUsers(doc map[string]any) error {
+	users, err := docUsers(doc)
+	if err != nil {
+		return err
+	}
+	for id, raw := range users {
+		u, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("user %s is %T, want object", id, raw)
+		}
+		if u["inventory"] == nil {
+			u["inventory"] = []any{}
+		}
+		if u["businesses"] == nil {
+			u["businesses"] = []any{}
+		}
+		if n, _ := u["farm_capacity"].(json.Number); n == "" || n == "0" {
+			u["farm_capacity"] = 95
+		}
+	}
+	return nil
+}
+
+// migrateBusinessLevels turns the plain list of owned business IDs into
+// level-1 business records. The purchase date is unknown, so the account
+// creation date stands in for it.
+func migrateBusinessLevels(doc map[string]any) error {
+	users, err := docUsers(doc)
+	if err != nil {
+		return err
+	}
+	for id, raw := range users {
+		u, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("user %s is %T, want object", id, raw)
+		}
+		ids, _ := u["businesses"].([]any)
+		owned := make([]any, 0, len(ids))
+		for _, bizID := range ids {
+			owned = append(owned, map[string]any{
+				"id":           bizID,
+				"level":        1,
+				"purchased_at": u["created_at"],
+				"total_earned": 0,
+			})
+		}
+		u["businesses"] = owned
+	}
+	return nil
+}
+
+// migrateUSDBusinessEarnings converts total_earned of the businesses that
+// switched to USD payouts from BTC at the rate in effect at the time.
+func migrateUSDBusinessEarnings(doc map[string]any) error {
+	const rate = 112937.0
+	usdBusinesses := map[string]bool{"4": true, "5": true, "7": true, "8": true}
+
+	users, err := docUsers(doc)
+	if err != nil {
+		return err
+	}
+	for id, raw := range users {
+		u, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("user %s is %T, want object", id, raw)
+		}
+		owned, _ := u["businesses"].([]any)
+		for _, rawBiz := range owned {
+			b, ok := rawBiz.(map[string]any)
+			if !ok {
+				continue
+			}
+			bizID := fmt.Sprint(b["id"])
+			earned, ok := b["total_earned"].(json.Number)
+			if !ok || !usdBusinesses[bizID] {
+				continue
+			}
+			f, err := earned.Float64()
+			if err != nil {
+				return fmt.Errorf("user %s business %s: %w", id, bizID, err)
+			}
+			b["total_earned"] = f * rate
+		}
+	}
+	return nil
+}
+
+// migrateGPURecords turns the list of owned GPU catalog IDs into records that
+// carry per-card durability, starting every card in mint condition.
+func migrateGPURecords(doc map[string]any) error {
+	users, err := docUsers(doc)
+	if err != nil {
+		return err
+	}
+	for id, raw := range users {
+		u, ok := raw.(map[string]any)
+		if !ok {
+			return fmt.Errorf("user %s is %T, want object", id, raw)
+		}
+		ids, _ := u["inventory"].([]any)
+		cards := make([]any, 0, len(ids))
+		for _, gpuID := range ids {
+			cards = append(cards, map[string]any{
+				"gpu_id":     gpuID,
+				"durability": 100,
+				"broken":     false,
+			})
+		}
+		u["inventory"] = cards
+	}
+	return nil
+}
+
+// migrateGPUInstanceIDs gives every owned card a store-wide unique instance
+// ID. Purchase prices of existing cards are unknown and stay zero; the account
+// creation date stands in for the purchase date.
+func migrateGPUInstanceIDs(doc map[string]any) error {
+	users, err := docUsers(doc)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(users))
+	for id := range users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var next int64
+	for _, id := range ids {
+		u, ok := users[id].(map[string]any)
+		if !ok {
+			return fmt.Errorf("user %s is %T, want object", id, users[id])
+		}
+		cards, _ := u["inventory"].([]any)
+		for _, rawCard := range cards {
+			card, ok := rawCard.(map[string]any)
+			if !ok {
+				return fmt.Errorf("user %s has GPU record %T, want object", id, rawCard)
+			}
+			next++
+			card["instance_id"] = next
+			card["purchase_price"] = 0
+			card["purchased_at"] = u["created_at"]
+		}
+	}
+	doc["next_gpu_id"] = next
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"time"
+)
+
+// difficultyMaxStep bounds how much a single adjustment may move difficulty in
+// either direction.
+const difficultyMaxStep = 4.0
+
+// halvingNotices are the distances in blocks at which an upcoming halving is
+// announced to every player.
+var halvingNotices = []int64{144, 6}
+
+// Network is the state shared by every miner. Difficulty divides all mining
+// output; it never drops below 1, so a small network mines at catalog rates.
+type Network struct {
+	Difficulty  float64   `json:"difficulty"`
+	Hashrate    float64   `json:"hashrate"`
+	AdjustedAt  time.Time `json:"adjusted_at"`
+	Height      int64     `json:"height"`
+	LastBlockAt time.Time `json:"last_block_at"`
+}
+
+func networkDifficulty() float64 {
+	return math.Max(1, store.Network.Difficulty)
+}
+
+// networkHashrate sums the BTC-equivalent hashrate of every farm that is
+// currently mining. The caller must hold storeMu.
+func networkHashrate(now time.Time) float64 {
+	var rate float64
+	for _, u := range store.Users {
+		if now.Before(u.MiningWindowEnd) {
+			rate += totalMiningRate(u)
+		}
+	}
+	return rate
+}
+
+// adjustDifficulty retargets difficulty so that the whole network mines about
+// cfg.DifficultyTargetHashrate per 10 minutes. The caller must hold storeMu.
+func adjustDifficulty(now time.Time) {
+	hashrate := networkHashrate(now)
+	prev := networkDifficulty()
+	target := hashrate / cfg.DifficultyTargetHashrate
+	next := math.Max(1, math.Min(math.Max(target, prev/difficultyMaxStep), prev*difficultyMaxStep))
+
+	store.Network.Difficulty = next
+	store.Network.Hashrate = hashrate
+	store.Network.AdjustedAt = now
+	if next != prev {
+		log.Printf("Difficulty adjusted from %.4f to %.4f (network hashrate %.7f BTC/10min)", prev, next, hashrate)
+	}
+}
+
+func halvings() int64 {
+	return store.Network.Height / int64(cfg.HalvingInterval)
+}
+
+// blockReward is the share of the original BTC block reward still paid out.
+func blockReward() float64 {
+	return math.Pow(0.5, float64(halvings()))
+}
+
+func blocksToHalving() int64 {
+	return int64(cfg.HalvingInterval) - store.Network.Height%int64(cfg.HalvingInterval)
+}
+
+// mineBlock advances the chain by one block and returns the announcement to
+// broadcast, if the block is a halving or close to one. The caller must hold
+// storeMu.
+func mineBlock(now time.Time) string {
+	store.Network.Height++
+	store.Network.LastBlockAt = now
+
+	if store.Network.Height%int64(cfg.HalvingInterval) == 0 {
+		log.Printf("Halving at block %d, BTC reward is now %.4f of the original", store.Network.Height, blockReward())
+		return fmt.Sprintf("⛏ *Халвинг!*\n\nНа блоке #%d награда за добычу BTC сократилась вдвое и теперь составляет %.2f%% от исходной",
+			store.Network.Height, blockReward()*100)
+	}
+	if left := blocksToHalving(); slices.Contains(halvingNotices, left) {
+		return fmt.Sprintf("⏳ *Скоро халвинг*\n\nЧерез %d блоков (~%s) награда за добычу BTC уменьшится вдвое",
+			left, formatPayback(time.Duration(left)*cfg.BlockInterval.Duration))
+	}
+	return ""
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"time"
+)
+
+type FlushMetrics struct {
+	Flushes       int
+	WorldFlushes  int
+	Errors        int
+	LastUsers     int
+	LastBytes     int
+	LastDuration  time.Duration
+	MaxDuration   time.Duration
+	TotalDuration time.Duration
+	LastFlushAt   time.Time
+}
+
+var (
+	// userRecords caches each user's serialized record, so a flush only
+	// marshals the users marked dirty since the last one. It is nil until the
+	// first flush fills it. Guarded by storeMu.
+	userRecords  map[int64]json.RawMessage
+	dirtyUsers   = map[int64]struct{}{}
+	storeDirty   bool
+	worldDirty   bool
+	flushNotify  = make(chan struct{}, 1)
+	flushMetrics FlushMetrics
+)
+
+// markDirty schedules u for the next flush. The caller must hold storeMu.
+func markDirty(u *User) {
+	dirtyUsers[u.ID] = struct{}{}
+	if len(dirtyUsers) >= cfg.FlushMaxDirty {
+		select {
+		case flushNotify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// markStoreDirty schedules a flush for changes outside user records, such as
+// market state. The caller must hold storeMu.
+func markStoreDirty() {
+	storeDirty = true
+}
+
+// worldState is the part of the store that the game clock changes on its
+// own. It is written to worldFile on every tick, so that price and block
+// updates do not rewrite every user; users.json carries a copy as of its own
+// last flush.
+type worldState struct {
+	Coins   map[string]*CoinMarket `json:"coins"`
+	Network Network                `json:"network"`
+}
+
+// markWorldDirty schedules a write of coin markets and the network alone. The
+// caller must hold storeMu.
+func markWorldDirty() {
+	worldDirty = true
+}
+
+// applyWorldFile overrides s's world state with worldFile, which is at least
+// as recent as users.json. A missing or unreadable file leaves s as it is.
+func applyWorldFile(s *Store) {
+	data, err := os.ReadFile(worldFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var w worldState
+	if err == nil {
+		err = json.Unmarshal(data, &w)
+	}
+	if err != nil {
+		log.Printf("Ignoring %s, using world state from %s: %v", worldFile, usersFile, err)
+		return
+	}
+	if w.Coins != nil {
+		s.Coins = w.Coins
+	}
+	s.Network = w.Network
+}
+
+func runFlusher(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(cfg.FlushInterval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			flushStore()
+		case <-flushNotify:
+			flushStore()
+		case <-stop:
+			// The last flush rewrites every record, so a change that missed
+			// markDirty is still saved on shutdown.
+			storeMu.Lock()
+			userRecords = nil
+			storeDirty = true
+			storeMu.Unlock()
+			flushStore()
+			log.Printf("Store flusher stopped: %s", flushMetrics.summary())
+			return
+		}
+	}
+}
+
+// storeSnapshot is the users file assembled from already serialized parts:
+// the outer Users field shadows the embedded Store's.
+type storeSnapshot struct {
+	Store
+	Users map[int64]json.RawMessage `json:"users"`
+}
+
+// serializeDirtyUsers refreshes userRecords for the users in pending, or for
+// every user on the first call. The caller must hold storeMu.
+func serializeDirtyUsers(pending map[int64]struct{}) error {
+	if userRecords == nil {
+		userRecords = map[int64]json.RawMessage{}
+		pending = map[int64]struct{}{}
+		for id := range store.Users {
+			pending[id] = struct{}{}
+		}
+	}
+	for id := range pending {
+		u, ok := store.Users[id]
+		if !ok {
+			delete(userRecords, id)
+			continue
+		}
+		data, err := json.Marshal(u)
+		if err != nil {
+			return fmt.Errorf("marshal user %d: %w", id, err)
+		}
+		userRecords[id] = data
+	}
+	return nil
+}
+
+// flushStore writes what changed since the last flush. Under storeMu it only
+// serializes the dirty users and the small store-wide fields; assembling,
+// indenting and writing the file happen after the lock is released.
+func flushStore() {
+	start := time.Now()
+
+	storeMu.Lock()
+	if len(dirtyUsers) == 0 && !storeDirty && !worldDirty {
+		storeMu.Unlock()
+		return
+	}
+	var world []byte
+	var worldErr error
+	if worldDirty {
+		world, worldErr = json.MarshalIndent(worldState{Coins: store.Coins, Network: store.Network}, "", "  ")
+		worldDirty = false
+	}
+	var head []byte
+	var records map[int64]json.RawMessage
+	var err error
+	pending := dirtyUsers
+	if len(pending) > 0 || storeDirty {
+		dirtyUsers = map[int64]struct{}{}
+		storeDirty = false
+		err = serializeDirtyUsers(pending)
+		if err == nil {
+			rest := store
+			rest.Users = nil
+			head, err = json.Marshal(rest)
+			records = maps.Clone(userRecords)
+		}
+	}
+	storeMu.Unlock()
+
+	if world != nil {
+		worldErr = writeFileAtomic(worldFile, world)
+	}
+	var data []byte
+	if head != nil {
+		var snap storeSnapshot
+		if err = json.Unmarshal(head, &snap.Store); err == nil {
+			snap.Users = records
+			data, err = json.MarshalIndent(snap, "", "  ")
+		}
+		if err == nil {
+			err = writeFileAtomic(usersFile, data)
+		}
+	}
+	elapsed := time.Since(start)
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if worldErr != nil {
+		log.Printf("Error flushing world state: %v", worldErr)
+		worldDirty = true
+		flushMetrics.Errors++
+	} else if world != nil {
+		flushMetrics.WorldFlushes++
+	}
+	if err != nil {
+		log.Printf("Error flushing store: %v", err)
+		for id := range pending {
+			dirtyUsers[id] = struct{}{}
+		}
+		storeDirty = true
+		flushMetrics.Errors++
+		return
+	}
+	if head == nil {
+		return
+	}
+	flushMetrics.Flushes++
+	flushMetrics.LastUsers = len(pending)
+	flushMetrics.LastBytes = len(data)
+	flushMetrics.LastDuration = elapsed
+	flushMetrics.TotalDuration += elapsed
+	if elapsed > flushMetrics.MaxDuration {
+		flushMetrics.MaxDuration = elapsed
+	}
+	flushMetrics.LastFlushAt = time.Now()
+	if elapsed > time.Second {
+		log.Printf("Slow store flush: %d dirty users, %d bytes in %s", len(pending), len(data), elapsed)
+	}
+}
+
+func (m FlushMetrics) summary() string {
+	var avg time.Duration
+	if m.Flushes > 0 {
+		avg = m.TotalDuration / time.Duration(m.Flushes)
+	}
+	return fmt.Sprintf("%d flushes, %d world writes, %d errors, last %s, avg %s, max %s", m.Flushes, m.WorldFlushes, m.Errors, m.LastDuration, avg, m.MaxDuration)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
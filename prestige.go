@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const playerLeaderboardSize = 10
+
+// prestigeGPULevels lists the catalog cards sold only to players of at least
+// the given prestige level.
+var prestigeGPULevels = map[int]int{
+	101: 1,
+	102: 2,
+	103: 3,
+}
+
+func buildPrestigeGPUs() []GPU {
+	return []GPU{
+		{101, "⭐ Prestige Miner P1", 0.0200000, 600000, 450},
+		{102, "⭐⭐ Prestige Miner P2", 0.0350000, 900000, 500},
+		{103, "⭐⭐⭐ Prestige Miner P3", 0.0600000, 1350000, 550},
+	}
+}
+
+func gpuMinPrestige(id int) int {
+	return prestigeGPULevels[id]
+}
+
+// prestigeMultiplier is the permanent income multiplier from u's prestige
+// points, applied to mining and businesses.
+func prestigeMultiplier(u *User) float64 {
+	return 1 + cfg.PrestigeBonus*float64(u.PrestigePoints)
+}
+
+// netWorthUSD values everything a prestige reset takes away: balances, coins,
+// cards at resale price, including ones listed on the market, funds reserved
+// by open exchange orders and what was invested in businesses and their vaults.
+func netWorthUSD(u *User) float64 {
+	worth := u.BalanceUSD + valueUSD(u.BalanceBTC, currencyBTC)
+	for symbol, amount := range u.Wallet {
+		worth += amount * coinPrice(symbol)
+	}
+	for _, card := range u.Inventory {
+		worth += resalePrice(card)
+	}
+	for _, l := range store.Listings {
+		if l.Seller == u.ID {
+			worth += resalePrice(l.Card)
+		}
+	}
+	for _, o := range store.Orders {
+		if o.UserID != u.ID {
+			continue
+		}
+		if o.Side == sideBid {
+			worth += o.remaining() * o.Price
+		} else {
+			worth += valueUSD(o.remaining(), currencyBTC)
+		}
+	}
+	for _, ob := range u.Businesses {
+		worth += businessInvested(ob) + valueUSD(ob.Vault, bizByID[ob.ID].Currency)
+	}
+	return worth
+}
+
+// prestigePointsFor is how many points a reset would give u now. Points grow
+// with the square root of net worth, so each one costs more than the last.
+func prestigePointsFor(u *User) int {
+	return int(math.Sqrt(netWorthUSD(u) / cfg.PrestigeUnitUSD))
+}
+
+// resetForPrestige wipes u's progress and grants the points. Market listings
+// and open exchange orders are cancelled first, returning the cards and the
+// reserved funds to u as cancelListing and cancelOrder do, so that they are
+// wiped together with the rest of its net worth; unconfirmed transfers are
+// dropped.
+func resetForPrestige(u *User, points int) {
+	listings := store.Listings[:0]
+	for _, l := range store.Listings {
+		if l.Seller == u.ID {
+			u.Inventory = append(u.Inventory, l.Card)
+			continue
+		}
+		listings = append(listings, l)
+	}
+	store.Listings = listings
+	orders := store.Orders[:0]
+	for _, o := range store.Orders {
+		switch {
+		case o.UserID != u.ID:
+			orders = append(orders, o)
+		case o.Side == sideBid:
+			u.BalanceUSD += o.remaining() * o.Price
+		default:
+			u.BalanceBTC += o.remaining()
+		}
+	}
+	store.Orders = orders
+	for id, p := range pendingTransfers {
+		if p.From == u.ID {
+			delete(pendingTransfers, id)
+		}
+	}
+	markStoreDirty()
+
+	u.BalanceUSD = cfg.StartBalanceUSD
+	u.BalanceBTC = cfg.StartBalanceBTC
+	u.Wallet = nil
+	u.UnsoldMinedBTC = 0
+	u.Inventory = []OwnedGPU{}
+	u.Businesses = []OwnedBusiness{}
+	u.FarmCapacity = cfg.FarmCapacity
+	u.Boosts = nil
+	u.Items = nil
+	u.Prestige++
+	u.PrestigePoints += points
+}
+
+func sendPrestige(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	points := prestigePointsFor(u)
+	text := "✨ *Перерождение*\n\n"
+	text += "Перерождение обнуляет балансы, видеокарты, бизнесы, расходники и ускорители, а взамен даёт очки престижа. "
+	text += fmt.Sprintf("Каждое очко навсегда увеличивает доход фермы и бизнесов на %.0f%%.\n\n", cfg.PrestigeBonus*100)
+	text += fmt.Sprintf("• Уровень престижа: %d\n", u.Prestige)
+	text += fmt.Sprintf("• Очки престижа: %d (доход ×%.2f)\n", u.PrestigePoints, prestigeMultiplier(u))
+	text += fmt.Sprintf("• Состояние: %.0f $\n", netWorthUSD(u))
+	text += fmt.Sprintf("• Очков за перерождение: %d\n", points)
+	if points > 0 {
+		text += fmt.Sprintf("• Доход после перерождения: ×%.2f\n", 1+cfg.PrestigeBonus*float64(u.PrestigePoints+points))
+	} else {
+		text += fmt.Sprintf("\nДля первого очка нужно состояние от %.0f $\n", cfg.PrestigeUnitUSD)
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+
+	var kbRows [][]tgbotapi.InlineKeyboardButton
+	if points > 0 {
+		kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✨ Переродиться", "prestige_confirm"),
+		))
+	}
+	kbRows = append(kbRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "stats"),
+	))
+	sendMessageWithKeyboard(chatID, text, tgbotapi.NewInlineKeyboardMarkup(kbRows...))
+}
+
+func confirmPrestige(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	points := prestigePointsFor(u)
+	if points == 0 {
+		sendPrestige(u, chatID)
+		return
+	}
+	text := fmt.Sprintf("⚠️ *Вы уверены?*\n\nВы потеряете %.0f $ состояния: все балансы, %d видеокарт и %d бизнесов. Лоты на рынке и заявки на бирже будут сняты, а видеокарты и средства в них учтены в состоянии и тоже обнулятся.\n\nВы получите очков престижа: %d\n\n%s",
+		netWorthUSD(u), len(u.Inventory), len(u.Businesses), points, currentTime)
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Да, переродиться", "prestige_do"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "prestige"),
+		),
+	)
+	sendMessageWithKeyboard(chatID, text, kb)
+}
+
+// doPrestige recomputes the points at the moment of the reset, so a stale
+// confirmation cannot grant more than the player has now.
+func doPrestige(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	points := prestigePointsFor(u)
+	if points == 0 {
+		sendMessage(chatID, fmt.Sprintf("Состояния недостаточно для перерождения\n\n%s", currentTime))
+		return
+	}
+	worth := netWorthUSD(u)
+	resetForPrestige(u, points)
+	log.Printf("User %d prestiged to level %d (+%d points, net worth %.2f)", u.ID, u.Prestige, points, worth)
+
+	sendMessage(chatID, fmt.Sprintf("✨ *Перерождение завершено!*\n\nУровень престижа: %d\nПолучено очков: %d\nДоход теперь ×%.2f\n\n%s",
+		u.Prestige, points, prestigeMultiplier(u), currentTime))
+	sendMainMenu(u, chatID)
+}
+
+func sendPlayerLeaderboard(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	users := make([]*User, 0, len(store.Users))
+	worth := map[int64]float64{}
+	for _, p := range store.Users {
+		users = append(users, p)
+		worth[p.ID] = netWorthUSD(p)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if a.Prestige != b.Prestige {
+			return a.Prestige > b.Prestige
+		}
+		if worth[a.ID] != worth[b.ID] {
+			return worth[a.ID] > worth[b.ID]
+		}
+		return a.ID < b.ID
+	})
+
+	text := "🏆 *Рейтинг игроков*\n\n"
+	for i, p := range users[:min(len(users), playerLeaderboardSize)] {
+		text += fmt.Sprintf("%d. @%s — ⭐ %d, %.0f $\n", i+1, p.Username, p.Prestige, worth[p.ID])
+	}
+	for i, p := range users {
+		if p.ID == u.ID && i >= playerLeaderboardSize {
+			text += fmt.Sprintf("…\n%d. @%s — ⭐ %d, %.0f $\n", i+1, p.Username, p.Prestige, worth[p.ID])
+		}
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "stats"),
+		),
+	)
+	sendMessageWithKeyboard(chatID, text, kb)
+}
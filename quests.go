@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	questBuyGPU      = "buy_gpu"
+	questConvertBTC  = "convert_btc"
+	questClaimBonus  = "claim_bonus"
+	questMarketTrade = "market_trade"
+
+	dailyQuestCount  = 3
+	weeklyQuestCount = 2
+
+	// questMinTradeUSD is the smallest deal that counts toward a market
+	// trade quest.
+	questMinTradeUSD = 1000
+)
+
+// QuestTemplate describes a kind of task. A generated quest picks one of
+// DailyGoals or WeeklyGoals for its period and pays Reward once it is reached.
+type QuestTemplate struct {
+	Kind        string
+	Title       func(goal float64) string
+	DailyGoals  []float64
+	WeeklyGoals []float64
+	Reward      func(goal float64, weekly bool) BonusReward
+}
+
+// Quest is one task on a player's quest board. Start is the beginning of the
+// day or week it belongs to; the quest disappears when that period ends.
+type Quest struct {
+	Kind     string      `json:"kind"`
+	Weekly   bool        `json:"weekly,omitempty"`
+	Start    time.Time   `json:"start"`
+	Goal     float64     `json:"goal"`
+	Progress float64     `json:"progress"`
+	Reward   BonusReward `json:"reward"`
+	Done     bool        `json:"done,omitempty"`
+}
+
+var questTemplates = []QuestTemplate{
+	{
+		Kind:        questBuyGPU,
+		Title:       func(goal float64) string { return fmt.Sprintf("Купите видеокарты: %.0f шт.", goal) },
+		DailyGoals:  []float64{1, 3, 5},
+		WeeklyGoals: []float64{10, 20},
+		Reward: func(goal float64, weekly bool) BonusReward {
+			return BonusReward{Kind: rewardUSD, Amount: 300 * goal}
+		},
+	},
+	{
+		Kind:        questConvertBTC,
+		Title:       func(goal float64) string { return fmt.Sprintf("Продайте добытые %.3f BTC", goal) },
+		DailyGoals:  []float64{0.001, 0.005},
+		WeeklyGoals: []float64{0.05, 0.1},
+		Reward: func(goal float64, weekly bool) BonusReward {
+			return BonusReward{Kind: rewardUSD, Amount: goal * coinPrice(coinBTC) * 0.2}
+		},
+	},
+	{
+		Kind: questClaimBonus,
+		Title: func(goal float64) string {
+			if goal == 1 {
+				return "Заберите ежедневный бонус"
+			}
+			return fmt.Sprintf("Заберите ежедневный бонус %.0f раз", goal)
+		},
+		DailyGoals:  []float64{1},
+		WeeklyGoals: []float64{5, 7},
+		Reward: func(goal float64, weekly bool) BonusReward {
+			if weekly {
+				return BonusReward{Kind: rewardBoost, Amount: 2, Duration: time.Duration(goal) * time.Hour}
+			}
+			return BonusReward{Kind: rewardBTC, Amount: cfg.DailyBonusBTC / 2}
+		},
+	},
+	{
+		Kind: questMarketTrade,
+		Title: func(goal float64) string {
+			return fmt.Sprintf("Совершите сделки с игроками на рынке или бирже от %d $: %.0f", questMinTradeUSD, goal)
+		},
+		DailyGoals:  []float64{1, 2},
+		WeeklyGoals: []float64{5, 10},
+		Reward: func(goal float64, weekly bool) BonusReward {
+			return BonusReward{Kind: rewardUSD, Amount: 500 * goal}
+		},
+	},
+}
+
+func questTemplate(kind string) (QuestTemplate, bool) {
+	for _, t := range questTemplates {
+		if t.Kind == kind {
+			return t, true
+		}
+	}
+	return QuestTemplate{}, false
+}
+
+// questPeriodStart is the start of the day, or of the week starting on
+// Monday, that contains now.
+func questPeriodStart(now time.Time, weekly bool) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !weekly {
+		return day
+	}
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+func questPeriodEnd(start time.Time, weekly bool) time.Time {
+	if weekly {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// refreshQuests drops quests from past periods and draws a new board for
+// every period that has none. The caller must hold storeMu, which also
+// guards rng.
+func refreshQuests(u *User, now time.Time) {
+	current := u.Quests[:0]
+	for _, q := range u.Quests {
+		if q.Start.Equal(questPeriodStart(now, q.Weekly)) {
+			current = append(current, q)
+		}
+	}
+	u.Quests = current
+
+	for _, weekly := range []bool{false, true} {
+		start := questPeriodStart(now, weekly)
+		if slices.ContainsFunc(u.Quests, func(q Quest) bool { return q.Weekly == weekly }) {
+			continue
+		}
+		count := dailyQuestCount
+		if weekly {
+			count = weeklyQuestCount
+		}
+		for _, i := range rng.Perm(len(questTemplates))[:min(count, len(questTemplates))] {
+			t := questTemplates[i]
+			goals := t.DailyGoals
+			if weekly {
+				goals = t.WeeklyGoals
+			}
+			goal := goals[rng.Intn(len(goals))]
+			u.Quests = append(u.Quests, Quest{Kind: t.Kind, Weekly: weekly, Start: start, Goal: goal, Reward: t.Reward(goal, weekly)})
+		}
+		markDirty(u)
+	}
+}
+
+// advanceQuests adds amount to u's open quests of kind and pays out the ones
+// that are now complete.
+func advanceQuests(u *User, kind string, amount float64) {
+	now := time.Now()
+	refreshQuests(u, now)
+	for i := range u.Quests {
+		q := &u.Quests[i]
+		if q.Kind != kind || q.Done {
+			continue
+		}
+		q.Progress = min(q.Goal, q.Progress+amount)
+		if q.Progress < q.Goal {
+			continue
+		}
+		q.Done = true
+		paid := grantBonusReward(u, q.Reward, now)
+		t, _ := questTemplate(q.Kind)
+		log.Printf("User %d completed quest %s (goal %g, weekly %t)", u.ID, q.Kind, q.Goal, q.Weekly)
+		queueNotice(u.ID, fmt.Sprintf("📋 *Задание выполнено!*\n\n%s\nНаграда: %s\n\n%s", t.Title(q.Goal), paid, now.Format("15:04")))
+	}
+	markDirty(u)
+}
+
+func sendQuests(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	now := time.Now()
+	refreshQuests(u, now)
+
+	text := "📋 *Задания*\n"
+	for _, weekly := range []bool{false, true} {
+		title := "Ежедневные"
+		if weekly {
+			title = "Еженедельные"
+		}
+		end := questPeriodEnd(questPeriodStart(now, weekly), weekly)
+		text += fmt.Sprintf("\n*%s* (обновятся через %s)\n", title, formatPayback(end.Sub(now)))
+		for _, q := range u.Quests {
+			if q.Weekly != weekly {
+				continue
+			}
+			t, ok := questTemplate(q.Kind)
+			if !ok {
+				continue
+			}
+			if q.Done {
+				text += fmt.Sprintf("✅ %s\n", t.Title(q.Goal))
+				continue
+			}
+			decimals := 0
+			if q.Kind == questConvertBTC {
+				decimals = 3
+			}
+			text += fmt.Sprintf("▫️ %s\n%s %.*f/%.*f · награда: %s\n", t.Title(q.Goal), progressBar(q.Progress/q.Goal), decimals, q.Progress, decimals, q.Goal, q.Reward)
+		}
+	}
+	text += fmt.Sprintf("\n%s", currentTime)
+
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "main_menu"),
+		),
+	)
+	sendMessageWithKeyboard(chatID, text, kb)
+}
@@ -0,0 +1,69 @@
+package main
+
+import "time"
+
+// broadcastDelay spaces out broadcast messages to stay under Telegram's limit
+// of about 30 messages per second.
+const broadcastDelay = 40 * time.Millisecond
+
+// runGameClock advances world state that changes without player input, such
+// as coin prices and network difficulty.
+func runGameClock(stop <-chan struct{}) {
+	prices := time.NewTicker(cfg.PriceUpdateInterval.Duration)
+	defer prices.Stop()
+	difficulty := time.NewTicker(cfg.DifficultyInterval.Duration)
+	defer difficulty.Stop()
+	blocks := time.NewTicker(cfg.BlockInterval.Duration)
+	defer blocks.Stop()
+	for {
+		select {
+		case now := <-prices.C:
+			storeMu.Lock()
+			updateCoinPrices(now)
+			expirePendingTransfers(now)
+			markWorldDirty()
+			storeMu.Unlock()
+		case now := <-difficulty.C:
+			storeMu.Lock()
+			adjustDifficulty(now)
+			markWorldDirty()
+			storeMu.Unlock()
+		case now := <-blocks.C:
+			storeMu.Lock()
+			announcement := mineBlock(now)
+			win := drawSoloBlock(rng, now)
+			markWorldDirty()
+			var ids []int64
+			if announcement != "" {
+				ids = userIDs()
+			}
+			if win != nil {
+				queueNotice(win.UserID, win.Text)
+			}
+			unlockAndNotify()
+			if announcement != "" {
+				go broadcast(ids, announcement)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// userIDs lists every known player. The caller must hold storeMu.
+func userIDs() []int64 {
+	ids := make([]int64, 0, len(store.Users))
+	for id := range store.Users {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// broadcast sends text to every listed chat. It must not be called with
+// storeMu held.
+func broadcast(ids []int64, text string) {
+	for _, id := range ids {
+		deliver(outgoing{req: markdownMessage(id, text)})
+		time.Sleep(broadcastDelay)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const poolSolo = "solo"
+
+// soloWin is a block found by a solo miner, ready to be announced once storeMu
+// has been released.
+type soloWin struct {
+	UserID int64
+	Text   string
+}
+
+// drawSoloBlock decides whether the block at the current height was found by
+// a solo miner. Every active farm, pooled or not, has a chance proportional to
+// its share of the network hashrate; only solo miners collect the reward
+// themselves, and only while their farm has electricity. Candidates are
+// visited in ID order, so a seeded r gives a
+// reproducible draw. The caller must hold storeMu.
+func drawSoloBlock(r *rand.Rand, now time.Time) *soloWin {
+	total := networkHashrate(now)
+	if total <= 0 {
+		return nil
+	}
+	var miners []*User
+	for _, u := range store.Users {
+		if miningPool(u.Pool).Solo && now.Before(u.MiningWindowEnd) && farmPowered(u) && totalMiningRate(u) > 0 {
+			miners = append(miners, u)
+		}
+	}
+	if len(miners) == 0 {
+		return nil
+	}
+	sort.Slice(miners, func(i, j int) bool { return miners[i].ID < miners[j].ID })
+
+	pick := r.Float64() * total
+	for _, u := range miners {
+		rate := totalMiningRate(u)
+		if pick >= rate {
+			pick -= rate
+			continue
+		}
+		return &soloWin{UserID: u.ID, Text: creditSoloBlock(u, total/rate)}
+	}
+	return nil
+}
+
+// creditSoloBlock pays u the whole block: its own output over one block
+// interval, scaled up by the inverse of its network share so that the
+// expected income matches pool mining without the fee.
+func creditSoloBlock(u *User, scale float64) string {
+	periods := cfg.BlockInterval.Minutes() / 10
+	yields := miningYields(u)
+	for symbol, amount := range yields {
+		yields[symbol] = amount * periods * scale
+		addCoins(u, symbol, yields[symbol])
+	}
+	addMinedBTC(u, yields[coinBTC])
+	markDirty(u)
+	log.Printf("User %d found block %d solo", u.ID, store.Network.Height)
+	return fmt.Sprintf("🎉 *Вы нашли блок #%d!*\n\nНаграда соло-майнера: %s", store.Network.Height, formatYields(yields))
+}
+
+// soloBlockChance is the probability that u finds the next block.
+func soloBlockChance(u *User) float64 {
+	total := networkHashrate(time.Now())
+	rate := totalMiningRate(u)
+	if rate <= 0 || total <= 0 {
+		return 0
+	}
+	if !time.Now().Before(u.MiningWindowEnd) {
+		total += rate
+	}
+	return rate / total
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// setupSoloNetwork builds a network of three active farms with the same card:
+// solo miner 1 with one card, pooled miner 2 with one card and solo miner 3
+// with two, so their shares of the network are 1/4, 1/4 and 1/2.
+func setupSoloNetwork(t *testing.T, now time.Time) {
+	t.Helper()
+	saved := store
+	savedOutput := log.Writer()
+	t.Cleanup(func() {
+		store = saved
+		log.SetOutput(savedOutput)
+	})
+	log.SetOutput(io.Discard)
+
+	cfg = defaultConfig()
+	initCatalogs()
+	initCoinMarkets()
+	store = Store{Users: map[int64]*User{}}
+	g := gpuCatalog[0]
+	for _, f := range []struct {
+		id    int64
+		pool  string
+		cards int
+	}{{1, poolSolo, 1}, {2, miningPools[0].ID, 1}, {3, poolSolo, 2}} {
+		u := &User{ID: f.id, Pool: f.pool, BalanceUSD: 1000, MiningWindowEnd: now.Add(time.Hour)}
+		for i := 0; i < f.cards; i++ {
+			u.Inventory = append(u.Inventory, newGPU(g, 0))
+		}
+		store.Users[f.id] = u
+	}
+}
+
+func TestDrawSoloBlockSeeded(t *testing.T) {
+	now := time.Now()
+	setupSoloNetwork(t, now)
+
+	// Only solo miners take part in the walk, so miner 1 wins below 0.25 of
+	// the network, miner 3 below 0.75 and nobody above. The first draws of
+	// seeds 2, 1 and 5 are 0.167, 0.605 and 0.804.
+	for _, tc := range []struct {
+		seed   int64
+		winner int64
+	}{{2, 1}, {1, 3}, {5, 0}} {
+		win := drawSoloBlock(rand.New(rand.NewSource(tc.seed)), now)
+		if tc.winner == 0 {
+			if win != nil {
+				t.Fatalf("seed %d: got a win for user %d, want none", tc.seed, win.UserID)
+			}
+			continue
+		}
+		if win == nil || win.UserID != tc.winner {
+			t.Fatalf("seed %d: got %+v, want a win for user %d", tc.seed, win, tc.winner)
+		}
+		if store.Users[tc.winner].MinedBTC <= 0 {
+			t.Fatalf("seed %d: winner was not credited", tc.seed)
+		}
+	}
+	if store.Users[2].MinedBTC != 0 {
+		t.Fatal("pooled miner was credited a solo block")
+	}
+}
+
+func TestDrawSoloBlockFollowsChance(t *testing.T) {
+	now := time.Now()
+	setupSoloNetwork(t, now)
+
+	const draws = 20000
+	r := rand.New(rand.NewSource(42))
+	wins := map[int64]int{}
+	for i := 0; i < draws; i++ {
+		if win := drawSoloBlock(r, now); win != nil {
+			wins[win.UserID]++
+		}
+	}
+	for _, id := range []int64{1, 2, 3} {
+		u := store.Users[id]
+		want := 0.0
+		if miningPool(u.Pool).Solo {
+			want = soloBlockChance(u)
+		}
+		got := float64(wins[id]) / draws
+		if math.Abs(got-want) > 0.02 {
+			t.Errorf("user %d won %.3f of blocks, want %.3f", id, got, want)
+		}
+	}
+}
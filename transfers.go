@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	transferConfirmWindow = 5 * time.Minute
+	transferHistorySize   = 10
+	// transferLedgerSize is how many transfers the store keeps. Older ones are
+	// dropped, except those from the last 24 hours that the daily limit needs
+	// and the last transferHistorySize of every player, which /transfers shows.
+	transferLedgerSize = 1000
+)
+
+// Transfer is a ledger entry for a completed payment between two players. The
+// sender paid Amount plus Fee, the recipient received Amount.
+type Transfer struct {
+	ID       int64     `json:"id"`
+	From     int64     `json:"from"`
+	To       int64     `json:"to"`
+	Amount   float64   `json:"amount"`
+	Currency string    `json:"currency"`
+	Fee      float64   `json:"fee"`
+	At       time.Time `json:"at"`
+}
+
+// pendingTransfer waits for the sender to confirm it. Pending transfers live in
+// memory only and are guarded by storeMu.
+type pendingTransfer struct {
+	Transfer
+	ExpiresAt time.Time
+}
+
+var (
+	pendingTransfers   = map[int64]*pendingTransfer{}
+	nextPendingID      int64
+	transferCurrencies = map[string]string{"btc": currencyBTC, "usd": currencyUSD}
+)
+
+func transferFee(amount float64) float64 {
+	return amount * cfg.TransferFeeRate
+}
+
+// sentTodayUSD is how much u has transferred in the last 24 hours, valued in
+// USD at current prices.
+func sentTodayUSD(u *User, now time.Time) float64 {
+	var total float64
+	for i := len(store.Transfers) - 1; i >= 0; i-- {
+		t := store.Transfers[i]
+		if now.Sub(t.At) > 24*time.Hour {
+			break
+		}
+		if t.From == u.ID {
+			total += valueUSD(t.Amount, t.Currency)
+		}
+	}
+	return total
+}
+
+func balanceIn(u *User, currency string) float64 {
+	if currency == currencyBTC {
+		return u.BalanceBTC
+	}
+	return u.BalanceUSD
+}
+
+// checkTransfer returns the reason u cannot send t, or "" if it can.
+func checkTransfer(u *User, t Transfer, now time.Time) string {
+	if balanceIn(u, t.Currency) < t.Amount+t.Fee {
+		return fmt.Sprintf("Недостаточно средств: нужно %s с учётом комиссии", formatAmount(t.Amount+t.Fee, t.Currency))
+	}
+	if left := cfg.TransferDailyLimitUSD - sentTodayUSD(u, now); valueUSD(t.Amount, t.Currency) > left {
+		return fmt.Sprintf("Превышен дневной лимит переводов: осталось %.0f $ на ближайшие 24 часа", max(0, left))
+	}
+	return ""
+}
+
+func startTransfer(u *User, parts []string, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	usage := fmt.Sprintf("Используйте: /send @username [количество] btc|usd\n\n%s", currentTime)
+	if len(parts) != 4 || !strings.HasPrefix(parts[1], "@") {
+		sendMessage(chatID, usage)
+		return
+	}
+	amount, err := strconv.ParseFloat(parts[2], 64)
+	currency, ok := transferCurrencies[strings.ToLower(parts[3])]
+	if err != nil || !ok || !(amount > 0) {
+		sendMessage(chatID, usage)
+		return
+	}
+	to, err := findUser(store, parts[1])
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Игрок %s не найден\n\n%s", parts[1], currentTime))
+		return
+	}
+	if to.ID == u.ID {
+		sendMessage(chatID, fmt.Sprintf("Нельзя перевести средства самому себе\n\n%s", currentTime))
+		return
+	}
+
+	now := time.Now()
+	t := Transfer{From: u.ID, To: to.ID, Amount: amount, Currency: currency, Fee: transferFee(amount)}
+	if reason := checkTransfer(u, t, now); reason != "" {
+		sendMessage(chatID, fmt.Sprintf("%s\n\n%s", reason, currentTime))
+		return
+	}
+
+	nextPendingID++
+	pendingTransfers[nextPendingID] = &pendingTransfer{Transfer: t, ExpiresAt: now.Add(transferConfirmWindow)}
+
+	text := "💸 *Подтвердите перевод*\n\n"
+	text += fmt.Sprintf("Получатель: @%s\n", to.Username)
+	text += fmt.Sprintf("Сумма: %s\n", formatAmount(t.Amount, t.Currency))
+	text += fmt.Sprintf("Комиссия: %s\n", formatAmount(t.Fee, t.Currency))
+	text += fmt.Sprintf("Будет списано: %s\n", formatAmount(t.Amount+t.Fee, t.Currency))
+	text += fmt.Sprintf("\nПодтвердить можно в течение %.0f минут\n\n%s", transferConfirmWindow.Minutes(), currentTime)
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Отправить", fmt.Sprintf("transfer_ok:%d", nextPendingID)),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", fmt.Sprintf("transfer_cancel:%d", nextPendingID)),
+		),
+	)
+	sendMessageWithKeyboard(chatID, text, kb)
+}
+
+// confirmTransfer settles a pending transfer. Both balances and the ledger
+// change under the same storeMu hold as the checks, so the transfer is
+// atomic.
+func confirmTransfer(u *User, id int64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	p, ok := pendingTransfers[id]
+	if !ok || p.From != u.ID {
+		sendMessage(chatID, fmt.Sprintf("Перевод не найден или уже обработан\n\n%s", currentTime))
+		return
+	}
+	delete(pendingTransfers, id)
+	now := time.Now()
+	if now.After(p.ExpiresAt) {
+		sendMessage(chatID, fmt.Sprintf("Время на подтверждение истекло, создайте перевод заново\n\n%s", currentTime))
+		return
+	}
+	to, ok := store.Users[p.To]
+	if !ok {
+		sendMessage(chatID, fmt.Sprintf("Получатель не найден\n\n%s", currentTime))
+		return
+	}
+	if reason := checkTransfer(u, p.Transfer, now); reason != "" {
+		sendMessage(chatID, fmt.Sprintf("%s\n\n%s", reason, currentTime))
+		return
+	}
+
+	t := p.Transfer
+	if n := len(store.Transfers); n > 0 && store.NextTransferID < store.Transfers[n-1].ID {
+		store.NextTransferID = store.Transfers[n-1].ID
+	}
+	store.NextTransferID++
+	t.ID = store.NextTransferID
+	t.At = now
+	creditIncome(u, -(t.Amount + t.Fee), t.Currency)
+	creditIncome(to, t.Amount, t.Currency)
+	store.Transfers = append(store.Transfers, t)
+	trimTransfers(now)
+	markDirty(to)
+	log.Printf("Transfer %d: %d -> %d, %.8f %s (fee %.8f)", t.ID, t.From, t.To, t.Amount, t.Currency, t.Fee)
+
+	sendMessage(chatID, fmt.Sprintf("✅ *Перевод выполнен*\n\n@%s получил %s\nКомиссия: %s\n\n%s",
+		to.Username, formatAmount(t.Amount, t.Currency), formatAmount(t.Fee, t.Currency), currentTime))
+	queueNotice(to.ID, fmt.Sprintf("💸 *Входящий перевод*\n\n@%s перевёл вам %s\n\n%s",
+		u.Username, formatAmount(t.Amount, t.Currency), currentTime))
+}
+
+// trimTransfers cuts the ledger down to transferLedgerSize entries, keeping
+// every transfer from the last 24 hours and each player's recent history. The
+// caller must hold storeMu.
+func trimTransfers(now time.Time) {
+	excess := len(store.Transfers) - transferLedgerSize
+	if excess <= 0 {
+		return
+	}
+	seen := map[int64]int{}
+	keep := make([]bool, len(store.Transfers))
+	for i := len(store.Transfers) - 1; i >= 0; i-- {
+		t := store.Transfers[i]
+		keep[i] = i >= excess || now.Sub(t.At) <= 24*time.Hour ||
+			seen[t.From] < transferHistorySize || seen[t.To] < transferHistorySize
+		seen[t.From]++
+		seen[t.To]++
+	}
+	kept := store.Transfers[:0]
+	for i, t := range store.Transfers {
+		if keep[i] {
+			kept = append(kept, t)
+		}
+	}
+	store.Transfers = kept
+}
+
+func cancelTransfer(u *User, id int64, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	if p, ok := pendingTransfers[id]; ok && p.From == u.ID {
+		delete(pendingTransfers, id)
+	}
+	sendMessage(chatID, fmt.Sprintf("Перевод отменён\n\n%s", currentTime))
+}
+
+// expirePendingTransfers drops confirmations nobody answered. The caller must
+// hold storeMu.
+func expirePendingTransfers(now time.Time) {
+	for id, p := range pendingTransfers {
+		if now.After(p.ExpiresAt) {
+			delete(pendingTransfers, id)
+		}
+	}
+}
+
+func sendTransferHistory(u *User, chatID int64) {
+	currentTime := time.Now().Format("15:04")
+	text := "💸 *Переводы*\n\n"
+	var shown int
+	for i := len(store.Transfers) - 1; i >= 0 && shown < transferHistorySize; i-- {
+		t := store.Transfers[i]
+		switch u.ID {
+		case t.From:
+			text += fmt.Sprintf("%s ➡️ @%s: −%s (комиссия %s)\n", t.At.Format("02.01 15:04"), usernameOf(t.To), formatAmount(t.Amount, t.Currency), formatAmount(t.Fee, t.Currency))
+		case t.To:
+			text += fmt.Sprintf("%s ⬅️ @%s: +%s\n", t.At.Format("02.01 15:04"), usernameOf(t.From), formatAmount(t.Amount, t.Currency))
+		default:
+			continue
+		}
+		shown++
+	}
+	if shown == 0 {
+		text += "Переводов пока не было\n"
+	}
+	text += fmt.Sprintf("\nОтправлено за сутки: %.0f / %.0f $\n", sentTodayUSD(u, time.Now()), cfg.TransferDailyLimitUSD)
+	text += fmt.Sprintf("\n%s", currentTime)
+	sendMessage(chatID, text)
+}
+
+func usernameOf(id int64) string {
+	if u, ok := store.Users[id]; ok {
+		return u.Username
+	}
+	return strconv.FormatInt(id, 10)
+}